@@ -0,0 +1,235 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nginx/nginx-prometheus-exporter/collector"
+	"github.com/nginx/nginx-prometheus-exporter/config"
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/clientcredentials"
+)
+
+// authError is the error of a request that could not be authenticated, because a
+// credentials file could not be read or no OAuth2 token could be fetched. It is
+// classified as an auth error, so it can be told apart from failures of NGINX.
+type authError struct {
+	err error
+}
+
+func (e *authError) Error() string {
+	return e.err.Error()
+}
+
+func (e *authError) Unwrap() error {
+	return e.err
+}
+
+// ScrapeErrorClass implements the collector.ClassifiedError interface.
+func (e *authError) ScrapeErrorClass() string {
+	return collector.ScrapeErrorAuth
+}
+
+// secretFile is a file holding a secret, such as a password or a bearer token. The file
+// is read again when its modification time or size changes, so the secret can be
+// rotated without restarting or reloading the exporter.
+type secretFile struct {
+	modTime time.Time
+	path    string
+	value   string
+	size    int64
+	mutex   sync.Mutex
+	// binary keeps the content of binary files as is, rather than trimming white space.
+	binary bool
+}
+
+// get returns the secret in the file without leading and trailing white space, unless
+// the file is binary.
+func (f *secretFile) get() (string, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	info, err := os.Stat(f.path)
+	if err != nil {
+		return "", fmt.Errorf("failed to read %q: %w", f.path, err)
+	}
+	if info.ModTime().Equal(f.modTime) && info.Size() == f.size {
+		return f.value, nil
+	}
+
+	data, err := os.ReadFile(f.path)
+	if err != nil {
+		return "", fmt.Errorf("failed to read %q: %w", f.path, err)
+	}
+	f.value = string(data)
+	if !f.binary {
+		f.value = strings.TrimSpace(f.value)
+	}
+	f.modTime = info.ModTime()
+	f.size = info.Size()
+	return f.value, nil
+}
+
+// oauth2TokenSource gets the access tokens of the OAuth2 client credentials flow. It
+// reuses a token until it expires, and starts over with a new token once the client
+// secret file changes. Tokens are fetched through the proxy and with the TLS settings of
+// the OAuth2 config.
+type oauth2TokenSource struct {
+	token      *oauth2.Token
+	httpClient *http.Client
+	secretFile *secretFile
+	config     clientcredentials.Config
+	mutex      sync.Mutex
+}
+
+func newOAuth2TokenSource(cfg *config.OAuth2) (*oauth2TokenSource, error) {
+	httpClient, _, err := newHTTPClient(cfg.TokenURL, config.Module{TLSConfig: cfg.TLSConfig, ProxyURL: cfg.ProxyURL})
+	if err != nil {
+		return nil, fmt.Errorf("creating OAuth2 token client failed: %w", err)
+	}
+
+	ts := &oauth2TokenSource{
+		httpClient: httpClient,
+		config: clientcredentials.Config{
+			ClientID:       cfg.ClientID,
+			ClientSecret:   cfg.ClientSecret,
+			TokenURL:       cfg.TokenURL,
+			Scopes:         cfg.Scopes,
+			EndpointParams: make(url.Values),
+		},
+	}
+	for name, value := range cfg.EndpointParams {
+		ts.config.EndpointParams.Set(name, value)
+	}
+	if cfg.ClientSecretFile != "" {
+		ts.secretFile = &secretFile{path: cfg.ClientSecretFile}
+	}
+	return ts, nil
+}
+
+// Token returns the current access token, or fetches a new one within ctx, which is the
+// context of the request to NGINX, so fetching is bounded by the scrape timeout.
+func (ts *oauth2TokenSource) Token(ctx context.Context) (*oauth2.Token, error) {
+	ts.mutex.Lock()
+	defer ts.mutex.Unlock()
+
+	if ts.secretFile != nil {
+		secret, err := ts.secretFile.get()
+		if err != nil {
+			return nil, fmt.Errorf("loading OAuth2 client secret failed: %w", err)
+		}
+		if secret != ts.config.ClientSecret {
+			ts.config.ClientSecret = secret
+			ts.token = nil
+		}
+	}
+	if ts.token.Valid() {
+		return ts.token, nil
+	}
+
+	token, err := ts.config.Token(context.WithValue(ctx, oauth2.HTTPClient, ts.httpClient))
+	if err != nil {
+		return nil, fmt.Errorf("fetching OAuth2 token from %q failed: %w", ts.config.TokenURL, err)
+	}
+	ts.token = token
+	return token, nil
+}
+
+// authRoundTripper sets the Authorization header of the requests to NGINX to the basic
+// authentication credentials, the bearer token or the OAuth2 access token.
+type authRoundTripper struct {
+	rt           http.RoundTripper
+	passwordFile *secretFile
+	tokenFile    *secretFile
+	tokenSource  *oauth2TokenSource
+	username     string
+	password     string
+}
+
+// newAuthRoundTripper wraps rt to authenticate the requests as configured by module. It
+// returns rt if module has no authentication configured, and an error if a password or
+// token file cannot be read. OAuth2 tokens are fetched with the first request.
+func newAuthRoundTripper(module config.Module, rt http.RoundTripper) (http.RoundTripper, error) {
+	authRT := &authRoundTripper{rt: rt}
+
+	switch {
+	case module.BasicAuth != nil:
+		authRT.username = module.BasicAuth.Username
+		authRT.password = module.BasicAuth.Password
+		if module.BasicAuth.PasswordFile != "" {
+			authRT.passwordFile = &secretFile{path: module.BasicAuth.PasswordFile}
+			if _, err := authRT.passwordFile.get(); err != nil {
+				return nil, fmt.Errorf("loading basic auth password failed: %w", err)
+			}
+		}
+	case module.BearerTokenFile != "":
+		authRT.tokenFile = &secretFile{path: module.BearerTokenFile}
+		if _, err := authRT.tokenFile.get(); err != nil {
+			return nil, fmt.Errorf("loading bearer token failed: %w", err)
+		}
+	case module.OAuth2 != nil:
+		var err error
+		if authRT.tokenSource, err = newOAuth2TokenSource(module.OAuth2); err != nil {
+			return nil, err
+		}
+		if authRT.tokenSource.secretFile != nil {
+			if _, err := authRT.tokenSource.secretFile.get(); err != nil {
+				return nil, fmt.Errorf("loading OAuth2 client secret failed: %w", err)
+			}
+		}
+	default:
+		return rt, nil
+	}
+
+	return authRT, nil
+}
+
+func (rt *authRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = cloneRequest(req)
+
+	switch {
+	case rt.tokenSource != nil:
+		token, err := rt.tokenSource.Token(req.Context())
+		if err != nil {
+			return nil, &authError{err: err}
+		}
+		token.SetAuthHeader(req)
+	case rt.tokenFile != nil:
+		token, err := rt.tokenFile.get()
+		if err != nil {
+			return nil, &authError{err: fmt.Errorf("loading bearer token failed: %w", err)}
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+	default:
+		password := rt.password
+		if rt.passwordFile != nil {
+			var err error
+			if password, err = rt.passwordFile.get(); err != nil {
+				return nil, &authError{err: fmt.Errorf("loading basic auth password failed: %w", err)}
+			}
+		}
+		req.SetBasicAuth(rt.username, password)
+	}
+
+	roundTrip, err := rt.rt.RoundTrip(req)
+	if err != nil {
+		return nil, fmt.Errorf("round trip failed: %w", err)
+	}
+	return roundTrip, nil
+}
+
+// CloseIdleConnections closes the idle connections of the wrapped transport.
+func (rt *authRoundTripper) CloseIdleConnections() {
+	if closer, ok := rt.rt.(interface{ CloseIdleConnections() }); ok {
+		closer.CloseIdleConnections()
+	}
+	if rt.tokenSource != nil {
+		rt.tokenSource.httpClient.CloseIdleConnections()
+	}
+}
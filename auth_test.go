@@ -0,0 +1,204 @@
+package main
+
+import (
+	"context"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/nginx/nginx-prometheus-exporter/collector"
+	"github.com/nginx/nginx-prometheus-exporter/config"
+)
+
+func TestNewHTTPClientAuth(t *testing.T) {
+	t.Parallel()
+
+	nginx := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, r.Header.Get("Authorization"))
+	}))
+	t.Cleanup(nginx.Close)
+
+	dir := t.TempDir()
+	passwordFile := filepath.Join(dir, "password")
+	tokenFile := filepath.Join(dir, "token")
+	writeFile := func(path, content string) {
+		t.Helper()
+		if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeFile(passwordFile, "s3cret\n")
+	writeFile(tokenFile, "token-1\n")
+
+	newClient := func(module config.Module) func() string {
+		t.Helper()
+		httpClient, endpoint, err := newHTTPClient(nginx.URL, module)
+		if err != nil {
+			t.Fatalf("newHTTPClient() returned error: %v", err)
+		}
+		t.Cleanup(httpClient.CloseIdleConnections)
+
+		// authorization returns the Authorization header received by NGINX
+		return func() string {
+			req, err := http.NewRequestWithContext(t.Context(), http.MethodGet, endpoint, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp, err := httpClient.Do(req)
+			if err != nil {
+				t.Fatalf("request returned error: %v", err)
+			}
+			defer resp.Body.Close()
+			body, err := io.ReadAll(resp.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			return string(body)
+		}
+	}
+
+	tests := []struct {
+		name   string
+		want   string
+		module config.Module
+	}{
+		{
+			name:   "basic auth with inline password",
+			module: config.Module{BasicAuth: &config.BasicAuth{Username: "prometheus", Password: "s3cret"}},
+			want:   "Basic cHJvbWV0aGV1czpzM2NyZXQ=",
+		},
+		{
+			name:   "basic auth with password file",
+			module: config.Module{BasicAuth: &config.BasicAuth{Username: "prometheus", PasswordFile: passwordFile}},
+			want:   "Basic cHJvbWV0aGV1czpzM2NyZXQ=",
+		},
+		{
+			name:   "bearer token file",
+			module: config.Module{BearerTokenFile: tokenFile},
+			want:   "Bearer token-1",
+		},
+		{
+			name: "no authentication",
+			want: "",
+		},
+	}
+	for _, tt := range tests {
+		if got := newClient(tt.module)(); got != tt.want {
+			t.Errorf("%s: Authorization = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+
+	// the token file is read again once it changes
+	authorization := newClient(config.Module{BearerTokenFile: tokenFile})
+	writeFile(tokenFile, "rotated-token-2")
+	if got, want := authorization(), "Bearer rotated-token-2"; got != want {
+		t.Errorf("Authorization after rotating the token = %q, want %q", got, want)
+	}
+
+	if _, _, err := newHTTPClient(nginx.URL, config.Module{BearerTokenFile: filepath.Join(dir, "missing")}); err == nil {
+		t.Error("newHTTPClient() with a missing token file returned no error")
+	}
+}
+
+func TestNewHTTPClientOAuth2(t *testing.T) {
+	t.Parallel()
+
+	var tokens, tokenRequests atomic.Int64
+	var failing atomic.Bool
+	tokenServer := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tokenRequests.Add(1)
+		id, secret, ok := r.BasicAuth()
+		if failing.Load() || !ok || id != "exporter" || secret != "s3cret" || r.FormValue("scope") != "nginx.read" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = fmt.Fprintf(w, `{"access_token":"token-%d","token_type":"Bearer","expires_in":3600}`, tokens.Add(1))
+	}))
+	t.Cleanup(tokenServer.Close)
+
+	nginx := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, r.Header.Get("Authorization"))
+	}))
+	t.Cleanup(nginx.Close)
+
+	dir := t.TempDir()
+	secretFile := filepath.Join(dir, "secret")
+	if err := os.WriteFile(secretFile, []byte("s3cret\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	// the token endpoint is only trusted through the tls_config of the OAuth2 config
+	caFile := filepath.Join(dir, "ca.pem")
+	caPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: tokenServer.Certificate().Raw})
+	if err := os.WriteFile(caFile, caPEM, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	httpClient, endpoint, err := newHTTPClient(nginx.URL, config.Module{
+		Timeout: time.Second,
+		OAuth2: &config.OAuth2{
+			ClientID:         "exporter",
+			ClientSecretFile: secretFile,
+			TokenURL:         tokenServer.URL,
+			Scopes:           []string{"nginx.read"},
+			TLSConfig:        config.TLSConfig{CAFile: caFile},
+		},
+	})
+	if err != nil {
+		t.Fatalf("newHTTPClient() returned error: %v", err)
+	}
+	t.Cleanup(httpClient.CloseIdleConnections)
+
+	get := func(ctx context.Context) (string, error) {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp, err := httpClient.Do(req)
+		if err != nil {
+			return "", err
+		}
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		return string(body), err
+	}
+
+	// the token is fetched once and reused until it expires
+	for range 2 {
+		if got, err := get(t.Context()); err != nil || got != "Bearer token-1" {
+			t.Errorf("Authorization = %q, %v, want %q", got, err, "Bearer token-1")
+		}
+	}
+	if got := tokens.Load(); got != 1 {
+		t.Errorf("fetched %v tokens, want 1", got)
+	}
+
+	// a changed client secret file starts over with a new token, which is fetched within
+	// the context of the request, and cannot be fetched once the token endpoint fails
+	failing.Store(true)
+	if err := os.WriteFile(secretFile, []byte("rotated-s3cret\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	canceled, cancel := context.WithCancel(t.Context())
+	cancel()
+	if _, err := get(canceled); err == nil {
+		t.Error("request with a canceled context returned no error")
+	}
+	if got := tokenRequests.Load(); got != 1 {
+		t.Errorf("token endpoint got %v requests, want 1", got)
+	}
+	_, err = get(t.Context())
+	if err == nil {
+		t.Fatal("request without a token returned no error")
+	}
+	if got := collector.ClassifyScrapeError(err); got != collector.ScrapeErrorAuth {
+		t.Errorf("ClassifyScrapeError(%q) = %q, want %q", err, got, collector.ScrapeErrorAuth)
+	}
+}
@@ -36,6 +36,31 @@ type StubConnections struct {
 	Waiting  int64
 }
 
+// StatusError is returned when NGINX responds to a stub_status request with a status
+// other than 200 OK.
+type StatusError struct {
+	StatusCode int
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("expected %v response, got %v", http.StatusOK, e.StatusCode)
+}
+
+// ParseError is returned when the response of NGINX to a stub_status request is not a
+// valid stub_status page.
+type ParseError struct {
+	Err  error
+	Body string
+}
+
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("failed to parse response body %q: %v", e.Body, e.Err)
+}
+
+func (e *ParseError) Unwrap() error {
+	return e.Err
+}
+
 // NewNginxClient creates an NginxClient.
 func NewNginxClient(httpClient *http.Client, apiEndpoint string) *NginxClient {
 	client := &NginxClient{
@@ -48,9 +73,12 @@ func NewNginxClient(httpClient *http.Client, apiEndpoint string) *NginxClient {
 
 // GetStubStats fetches the stub_status metrics.
 func (client *NginxClient) GetStubStats() (*StubStats, error) {
-	ctx, cancel := context.WithCancel(context.Background())
-	defer cancel()
+	return client.GetStubStatsWithContext(context.Background())
+}
 
+// GetStubStatsWithContext fetches the stub_status metrics. The request is canceled when
+// ctx is done.
+func (client *NginxClient) GetStubStatsWithContext(ctx context.Context) (*StubStats, error) {
 	req, err := http.NewRequestWithContext(ctx, http.MethodGet, client.apiEndpoint, nil)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create a get request: %w", err)
@@ -62,7 +90,7 @@ func (client *NginxClient) GetStubStats() (*StubStats, error) {
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("expected %v response, got %v", http.StatusOK, resp.StatusCode)
+		return nil, &StatusError{StatusCode: resp.StatusCode}
 	}
 
 	body, err := io.ReadAll(resp.Body)
@@ -73,7 +101,7 @@ func (client *NginxClient) GetStubStats() (*StubStats, error) {
 	r := bytes.NewReader(body)
 	stats, err := parseStubStats(r)
 	if err != nil {
-		return nil, fmt.Errorf("failed to parse response body %q: %w", string(body), err)
+		return nil, &ParseError{Body: string(body), Err: err}
 	}
 
 	return stats, nil
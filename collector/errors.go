@@ -0,0 +1,79 @@
+package collector
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"net"
+	"os"
+
+	plusclient "github.com/nginx/nginx-plus-go-client/v3/client"
+	"github.com/nginx/nginx-prometheus-exporter/client"
+)
+
+// Classes of scrape errors returned by ClassifyScrapeError.
+const (
+	ScrapeErrorDial       = "dial"
+	ScrapeErrorTLS        = "tls"
+	ScrapeErrorTimeout    = "timeout"
+	ScrapeErrorHTTPStatus = "http_status"
+	ScrapeErrorParse      = "parse"
+	ScrapeErrorAuth       = "auth"
+	ScrapeErrorUnknown    = "unknown"
+)
+
+// ClassifiedError is implemented by errors that know their class, such as the errors of
+// the round trippers of the HTTP client used by a collector.
+type ClassifiedError interface {
+	error
+	// ScrapeErrorClass returns one of the classes returned by ClassifyScrapeError.
+	ScrapeErrorClass() string
+}
+
+// ClassifyScrapeError returns the class of err, an error returned by Scrape, which tells
+// at which step the scrape failed: authenticating, connecting to NGINX, the TLS
+// handshake, waiting for the response, the status of the response or parsing the
+// response.
+func ClassifyScrapeError(err error) string {
+	var (
+		classifiedErr ClassifiedError
+		netErr        net.Error
+		opErr         *net.OpError
+		dnsErr        *net.DNSError
+		certErr       *tls.CertificateVerificationError
+		alertErr      tls.AlertError
+		recordErr     tls.RecordHeaderError
+		authorityErr  x509.UnknownAuthorityError
+		hostnameErr   x509.HostnameError
+		invalidErr    x509.CertificateInvalidError
+		statusErr     *client.StatusError
+		plusStatusErr plusclient.StatusError
+		parseErr      *client.ParseError
+		syntaxErr     *json.SyntaxError
+		typeErr       *json.UnmarshalTypeError
+	)
+
+	switch {
+	case err == nil:
+		return ""
+	case errors.As(err, &classifiedErr):
+		return classifiedErr.ScrapeErrorClass()
+	case errors.As(err, &certErr), errors.As(err, &alertErr), errors.As(err, &recordErr),
+		errors.As(err, &authorityErr), errors.As(err, &hostnameErr), errors.As(err, &invalidErr),
+		errors.As(err, &opErr) && opErr.Op == "remote error": // a TLS alert sent by NGINX
+		return ScrapeErrorTLS
+	case errors.Is(err, context.DeadlineExceeded), errors.Is(err, os.ErrDeadlineExceeded),
+		errors.As(err, &netErr) && netErr.Timeout():
+		return ScrapeErrorTimeout
+	case errors.As(err, &opErr) && opErr.Op == "dial", errors.As(err, &dnsErr):
+		return ScrapeErrorDial
+	case errors.As(err, &statusErr), errors.As(err, &plusStatusErr):
+		return ScrapeErrorHTTPStatus
+	case errors.As(err, &parseErr), errors.As(err, &syntaxErr), errors.As(err, &typeErr):
+		return ScrapeErrorParse
+	default:
+		return ScrapeErrorUnknown
+	}
+}
@@ -0,0 +1,82 @@
+package collector
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/nginx/nginx-prometheus-exporter/client"
+)
+
+func TestClassifyScrapeError(t *testing.T) {
+	t.Parallel()
+
+	closed, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	closedURL := "http://" + closed.Addr().String()
+	closed.Close()
+
+	respond := func(status int, body string) string {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+			w.WriteHeader(status)
+			_, _ = io.WriteString(w, body)
+		}))
+		t.Cleanup(server.Close)
+		return server.URL
+	}
+	slow := httptest.NewServer(http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	}))
+	t.Cleanup(slow.Close)
+	tlsServer := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = io.WriteString(w, validStubStatus)
+	}))
+	tlsServer.Config.ErrorLog = log.New(io.Discard, "", 0)
+	tlsServer.StartTLS()
+	t.Cleanup(tlsServer.Close)
+
+	tests := []struct {
+		name string
+		uri  string
+		want string
+	}{
+		{name: "refused connection", uri: closedURL, want: ScrapeErrorDial},
+		{name: "untrusted certificate", uri: tlsServer.URL, want: ScrapeErrorTLS},
+		{name: "timeout", uri: slow.URL, want: ScrapeErrorTimeout},
+		{name: "forbidden", uri: respond(http.StatusForbidden, "forbidden"), want: ScrapeErrorHTTPStatus},
+		{name: "invalid stub_status", uri: respond(http.StatusOK, "<html></html>"), want: ScrapeErrorParse},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			ctx := t.Context()
+			if tt.want == ScrapeErrorTimeout {
+				var cancel context.CancelFunc
+				ctx, cancel = context.WithTimeout(ctx, 100*time.Millisecond)
+				defer cancel()
+			}
+
+			_, err := client.NewNginxClient(&http.Client{}, tt.uri).GetStubStatsWithContext(ctx)
+			if err == nil {
+				t.Fatal("GetStubStatsWithContext() returned no error")
+			}
+			if got := ClassifyScrapeError(err); got != tt.want {
+				t.Errorf("ClassifyScrapeError(%q) = %q, want %q", err, got, tt.want)
+			}
+		})
+	}
+
+	if got := ClassifyScrapeError(errors.New("something else")); got != ScrapeErrorUnknown {
+		t.Errorf("ClassifyScrapeError() of an unknown error = %q, want %q", got, ScrapeErrorUnknown)
+	}
+}
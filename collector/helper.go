@@ -1,6 +1,8 @@
 package collector
 
 import (
+	"context"
+
 	"github.com/prometheus/client_golang/prometheus"
 )
 
@@ -9,10 +11,31 @@ const (
 	nginxDown = 0
 )
 
+// Scraper is a prometheus.Collector for a single NGINX or NGINX Plus instance that can
+// also be scraped with a context, for example to limit the duration of a scrape.
+type Scraper interface {
+	prometheus.Collector
+	// Scrape fetches metrics and sends them to the provided channel. It returns the
+	// error of a failed scrape.
+	Scrape(ctx context.Context, ch chan<- prometheus.Metric) error
+	// Run polls the instance in the background until ctx is canceled, if polling is
+	// enabled with WithPollInterval. Otherwise, it returns immediately.
+	Run(ctx context.Context)
+	// ScrapeErrors returns the number of failed requests for the stats of the instance by
+	// the class of their error, as returned by ClassifyScrapeError. When polling, a failed
+	// poll is counted once, no matter how often its stats are scraped.
+	ScrapeErrors() map[string]float64
+}
+
 func newGlobalMetric(namespace string, metricName string, docString string, constLabels map[string]string) *prometheus.Desc {
 	return prometheus.NewDesc(namespace+"_"+metricName, docString, nil, constLabels)
 }
 
+func newScrapeErrorMetric(namespace string, constLabels map[string]string) *prometheus.Desc {
+	return prometheus.NewDesc(namespace+"_scrape_error_info",
+		"Class of the error of the last metric scrape, only exported when it failed", []string{"reason"}, constLabels)
+}
+
 func newUpMetric(namespace string, constLabels map[string]string) prometheus.Gauge {
 	return prometheus.NewGauge(prometheus.GaugeOpts{
 		Namespace:   namespace,
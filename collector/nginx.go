@@ -1,8 +1,11 @@
 package collector
 
 import (
+	"context"
+	"fmt"
 	"log/slog"
 	"sync"
+	"time"
 
 	"github.com/nginx/nginx-prometheus-exporter/client"
 	"github.com/prometheus/client_golang/prometheus"
@@ -11,34 +14,42 @@ import (
 // NginxCollector collects NGINX metrics. It implements prometheus.Collector interface.
 type NginxCollector struct {
 	upMetric    prometheus.Gauge
+	errorMetric *prometheus.Desc
 	logger      *slog.Logger
 	nginxClient *client.NginxClient
+	stats       *statsSource[*client.StubStats]
 	metrics     map[string]*prometheus.Desc
 	mutex       sync.Mutex
 }
 
 // NewNginxCollector creates an NginxCollector.
-func NewNginxCollector(nginxClient *client.NginxClient, namespace string, constLabels map[string]string, logger *slog.Logger) *NginxCollector {
-	return &NginxCollector{
+func NewNginxCollector(nginxClient *client.NginxClient, namespace string, constLabels map[string]string, logger *slog.Logger, opts ...Option) *NginxCollector {
+	c := &NginxCollector{
 		nginxClient: nginxClient,
 		logger:      logger,
 		metrics: map[string]*prometheus.Desc{
-			"connections_active":   newGlobalMetric(namespace, "connections_active", "Active client connections", constLabels),
-			"connections_accepted": newGlobalMetric(namespace, "connections_accepted", "Accepted client connections", constLabels),
-			"connections_handled":  newGlobalMetric(namespace, "connections_handled", "Handled client connections", constLabels),
-			"connections_reading":  newGlobalMetric(namespace, "connections_reading", "Connections where NGINX is reading the request header", constLabels),
-			"connections_writing":  newGlobalMetric(namespace, "connections_writing", "Connections where NGINX is writing the response back to the client", constLabels),
-			"connections_waiting":  newGlobalMetric(namespace, "connections_waiting", "Idle client connections", constLabels),
-			"http_requests_total":  newGlobalMetric(namespace, "http_requests_total", "Total http requests", constLabels),
+			"connections_active":     newGlobalMetric(namespace, "connections_active", "Active client connections", constLabels),
+			"connections_accepted":   newGlobalMetric(namespace, "connections_accepted", "Accepted client connections", constLabels),
+			"connections_handled":    newGlobalMetric(namespace, "connections_handled", "Handled client connections", constLabels),
+			"connections_reading":    newGlobalMetric(namespace, "connections_reading", "Connections where NGINX is reading the request header", constLabels),
+			"connections_writing":    newGlobalMetric(namespace, "connections_writing", "Connections where NGINX is writing the response back to the client", constLabels),
+			"connections_waiting":    newGlobalMetric(namespace, "connections_waiting", "Idle client connections", constLabels),
+			"http_requests_total":    newGlobalMetric(namespace, "http_requests_total", "Total http requests", constLabels),
+			"snapshot_age":           newGlobalMetric(namespace, "snapshot_age_seconds", "Age of the polled stats the metrics are based on", constLabels),
+			"last_successful_scrape": newGlobalMetric(namespace, "last_successful_scrape_timestamp_seconds", "Time of the last successful scrape of NGINX (expressed as Unix Epoch Time)", constLabels),
 		},
-		upMetric: newUpMetric(namespace, constLabels),
+		upMetric:    newUpMetric(namespace, constLabels),
+		errorMetric: newScrapeErrorMetric(namespace, constLabels),
 	}
+	c.stats = newStatsSource(newOptions(opts), c.fetchStats)
+	return c
 }
 
 // Describe sends the super-set of all possible descriptors of NGINX metrics
 // to the provided channel.
 func (c *NginxCollector) Describe(ch chan<- *prometheus.Desc) {
 	ch <- c.upMetric.Desc()
+	ch <- c.errorMetric
 
 	for _, m := range c.metrics {
 		ch <- m
@@ -47,20 +58,40 @@ func (c *NginxCollector) Describe(ch chan<- *prometheus.Desc) {
 
 // Collect fetches metrics from NGINX and sends them to the provided channel.
 func (c *NginxCollector) Collect(ch chan<- prometheus.Metric) {
+	if err := c.Scrape(context.Background(), ch); err != nil {
+		c.logger.Error("error getting stats", "uri", c.nginxClient.GetAPIEndpoint(), "error", err)
+	}
+}
+
+// Scrape fetches metrics from NGINX and sends them to the provided channel. It returns
+// the error of a failed scrape, in which case only the up metric is sent, unless the
+// collector was created with WithStaleGracePeriod and the last successful scrape is
+// recent enough to send its metrics instead.
+func (c *NginxCollector) Scrape(ctx context.Context, ch chan<- prometheus.Metric) error {
 	c.mutex.Lock() // To protect metrics from concurrent collects
 	defer c.mutex.Unlock()
 
-	stats, err := c.nginxClient.GetStubStats()
-	if err != nil {
+	snap := c.stats.get(ctx)
+	if snap.err != nil {
 		c.upMetric.Set(nginxDown)
-		ch <- c.upMetric
-		c.logger.Error("error getting stats", "uri", c.nginxClient.GetAPIEndpoint(), "error", err)
-		return
+	} else {
+		c.upMetric.Set(nginxUp)
 	}
-
-	c.upMetric.Set(nginxUp)
 	ch <- c.upMetric
 
+	if snap.err != nil {
+		ch <- prometheus.MustNewConstMetric(c.errorMetric,
+			prometheus.GaugeValue, 1, ClassifyScrapeError(snap.err))
+	}
+	if !snap.timestamp.IsZero() {
+		ch <- prometheus.MustNewConstMetric(c.metrics["last_successful_scrape"],
+			prometheus.GaugeValue, float64(snap.timestamp.UnixNano())/1e9)
+	}
+	if !c.stats.servable(snap) {
+		return snap.err
+	}
+
+	stats := snap.stats
 	ch <- prometheus.MustNewConstMetric(c.metrics["connections_active"],
 		prometheus.GaugeValue, float64(stats.Connections.Active))
 	ch <- prometheus.MustNewConstMetric(c.metrics["connections_accepted"],
@@ -75,4 +106,31 @@ func (c *NginxCollector) Collect(ch chan<- prometheus.Metric) {
 		prometheus.GaugeValue, float64(stats.Connections.Waiting))
 	ch <- prometheus.MustNewConstMetric(c.metrics["http_requests_total"],
 		prometheus.CounterValue, float64(stats.Requests))
+
+	if c.stats.polling() {
+		ch <- prometheus.MustNewConstMetric(c.metrics["snapshot_age"],
+			prometheus.GaugeValue, time.Since(snap.timestamp).Seconds())
+	}
+
+	return snap.err
+}
+
+// Run polls NGINX in the background until ctx is canceled, if the collector was created
+// with WithPollInterval. Otherwise, it returns immediately.
+func (c *NginxCollector) Run(ctx context.Context) {
+	c.stats.run(ctx)
+}
+
+// ScrapeErrors returns the number of failed requests for the stats of NGINX by the class
+// of their error.
+func (c *NginxCollector) ScrapeErrors() map[string]float64 {
+	return c.stats.errorCounts()
+}
+
+func (c *NginxCollector) fetchStats(ctx context.Context) (*client.StubStats, error) {
+	stats, err := c.nginxClient.GetStubStatsWithContext(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get stub status: %w", err)
+	}
+	return stats, nil
 }
@@ -2,10 +2,16 @@ package collector
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log/slog"
+	"maps"
+	"net/http"
+	"regexp"
+	"slices"
 	"strconv"
 	"sync"
+	"time"
 
 	plusclient "github.com/nginx/nginx-plus-go-client/v3/client"
 	"github.com/prometheus/client_golang/prometheus"
@@ -32,9 +38,17 @@ type LabelUpdater interface {
 // NginxPlusCollector collects NGINX Plus metrics. It implements prometheus.Collector interface.
 type NginxPlusCollector struct {
 	upMetric                       prometheus.Gauge
+	errorMetric                    *prometheus.Desc
 	logger                         *slog.Logger
 	cacheZoneMetrics               map[string]*prometheus.Desc
 	workerMetrics                  map[string]*prometheus.Desc
+	slabMetrics                    map[string]*prometheus.Desc
+	keyvalMetrics                  map[string]*prometheus.Desc
+	streamKeyvalMetrics            map[string]*prometheus.Desc
+	keyvalKeys                     map[string]*regexp.Regexp
+	streamKeyvalKeys               map[string]*regexp.Regexp
+	keyvals                        plusclient.KeyValPairsByZone
+	streamKeyvals                  plusclient.KeyValPairsByZone
 	nginxClient                    *plusclient.NginxClient
 	streamServerZoneMetrics        map[string]*prometheus.Desc
 	streamZoneSyncMetrics          map[string]*prometheus.Desc
@@ -56,9 +70,34 @@ type NginxPlusCollector struct {
 	upstreamServerPeerLabels       map[string][]string
 	cacheZoneLabels                map[string][]string
 	totalMetrics                   map[string]*prometheus.Desc
+	stats                          *statsSource[plusStats]
 	variableLabelNames             VariableLabelNames
 	variableLabelsMutex            sync.RWMutex
 	mutex                          sync.Mutex
+	keyvalsMutex                   sync.Mutex
+	maxKeyvalKeys                  int
+}
+
+// plusStats are the stats of NGINX Plus along with its license, which is nil if it
+// could not be fetched, and the key-value pairs of its HTTP and stream keyval zones.
+type plusStats struct {
+	stats         *plusclient.Stats
+	license       *plusclient.NginxLicense
+	keyvals       plusclient.KeyValPairsByZone
+	streamKeyvals plusclient.KeyValPairsByZone
+}
+
+// WithKeyvalValues makes the NGINX Plus collector fetch the HTTP and stream keyval zones
+// of httpKeys and streamKeys, by zone name, and export their number of key-value pairs
+// and the numeric values of the keys matching their patterns, up to maxKeysPerZone keys
+// per zone. A zone with a nil pattern only exports its number of pairs. Other zones are
+// not requested, so keyvals are not fetched at all without this option.
+func WithKeyvalValues(httpKeys, streamKeys map[string]*regexp.Regexp, maxKeysPerZone int) Option {
+	return func(o *options) {
+		o.keyvalKeys = httpKeys
+		o.streamKeyvalKeys = streamKeys
+		o.maxKeyvalKeys = maxKeysPerZone
+	}
 }
 
 // UpdateUpstreamServerPeerLabels updates the Upstream Server Peer Labels.
@@ -256,13 +295,13 @@ func NewVariableLabelNames(upstreamServerVariableLabelNames []string, serverZone
 }
 
 // NewNginxPlusCollector creates an NginxPlusCollector.
-func NewNginxPlusCollector(nginxClient *plusclient.NginxClient, namespace string, variableLabelNames VariableLabelNames, constLabels map[string]string, logger *slog.Logger) *NginxPlusCollector {
+func NewNginxPlusCollector(nginxClient *plusclient.NginxClient, namespace string, variableLabelNames VariableLabelNames, constLabels map[string]string, logger *slog.Logger, opts ...Option) *NginxPlusCollector {
 	upstreamServerVariableLabelNames := variableLabelNames.UpstreamServerVariableLabelNames
 	streamUpstreamServerVariableLabelNames := variableLabelNames.StreamUpstreamServerVariableLabelNames
 
 	upstreamServerVariableLabelNames = append(upstreamServerVariableLabelNames, variableLabelNames.UpstreamServerPeerVariableLabelNames...)
 	streamUpstreamServerVariableLabelNames = append(streamUpstreamServerVariableLabelNames, variableLabelNames.StreamUpstreamServerPeerVariableLabelNames...)
-	return &NginxPlusCollector{
+	c := &NginxPlusCollector{
 		variableLabelNames:             variableLabelNames,
 		upstreamServerLabels:           make(map[string][]string),
 		serverZoneLabels:               make(map[string][]string),
@@ -287,6 +326,12 @@ func NewNginxPlusCollector(nginxClient *plusclient.NginxClient, namespace string
 			"license_reporting_healthy":      newGlobalMetric(namespace, "license_reporting_healthy", "Indicates whether the reporting state is still considered healthy despite recent failed attempts", constLabels),
 			"license_reporting_fails":        newGlobalMetric(namespace, "license_reporting_fails_count", "Number of failed reporting attempts, reset each time the usage report is successfully sent", constLabels),
 			"license_reporting_grace_period": newGlobalMetric(namespace, "license_reporting_grace_period_seconds", "Number of seconds before traffic processing is stopped after unsuccessful report attempt", constLabels),
+			"snapshot_age":                   newGlobalMetric(namespace, "snapshot_age_seconds", "Age of the polled stats the metrics are based on", constLabels),
+			"last_successful_scrape":         newGlobalMetric(namespace, "last_successful_scrape_timestamp_seconds", "Time of the last successful scrape of NGINX Plus (expressed as Unix Epoch Time)", constLabels),
+			"info":                           newInfoMetric(namespace, constLabels),
+			"config_generation":              newGlobalMetric(namespace, "config_generation", "Total number of configuration reloads", constLabels),
+			"load_timestamp":                 newGlobalMetric(namespace, "load_timestamp_seconds", "Time of the last reload of configuration (expressed as Unix Epoch Time)", constLabels),
+			"processes_respawned":            newGlobalMetric(namespace, "processes_respawned_total", "Total number of abnormally terminated and respawned child processes", constLabels),
 		},
 		serverZoneMetrics: map[string]*prometheus.Desc{
 			"processing":            newServerZoneMetric(namespace, "processing", "Client requests that are currently being processed", variableLabelNames.ServerZoneVariableLabelNames, constLabels),
@@ -357,8 +402,11 @@ func NewNginxPlusCollector(nginxClient *plusclient.NginxClient, namespace string
 			"ssl_session_reuses":    newStreamServerZoneMetric(namespace, "ssl_session_reuses", "Session reuses during SSL handshake", variableLabelNames.StreamServerZoneVariableLabelNames, constLabels),
 		},
 		upstreamMetrics: map[string]*prometheus.Desc{
-			"keepalive": newUpstreamMetric(namespace, "keepalive", "Idle keepalive connections", constLabels),
-			"zombies":   newUpstreamMetric(namespace, "zombies", "Servers removed from the group but still processing active client requests", constLabels),
+			"keepalive":       newUpstreamMetric(namespace, "keepalive", "Idle keepalive connections", constLabels),
+			"zombies":         newUpstreamMetric(namespace, "zombies", "Servers removed from the group but still processing active client requests", constLabels),
+			"queue_size":      newUpstreamMetric(namespace, "queue_size", "Current number of requests in the queue", constLabels),
+			"queue_max_size":  newUpstreamMetric(namespace, "queue_max_size", "Maximum number of requests that can be in the queue at the same time", constLabels),
+			"queue_overflows": newUpstreamMetric(namespace, "queue_overflows_total", "Total number of requests rejected due to the queue overflow", constLabels),
 		},
 		streamUpstreamMetrics: map[string]*prometheus.Desc{
 			"zombies": newStreamUpstreamMetric(namespace, "zombies", "Servers removed from the group but still processing active client connections", constLabels),
@@ -382,6 +430,12 @@ func NewNginxPlusCollector(nginxClient *plusclient.NginxClient, namespace string
 			"health_checks_checks":    newUpstreamServerMetric(namespace, "health_checks_checks", "Total health check requests", upstreamServerVariableLabelNames, constLabels),
 			"health_checks_fails":     newUpstreamServerMetric(namespace, "health_checks_fails", "Failed health checks", upstreamServerVariableLabelNames, constLabels),
 			"health_checks_unhealthy": newUpstreamServerMetric(namespace, "health_checks_unhealthy", "How many times the server became unhealthy (state 'unhealthy')", upstreamServerVariableLabelNames, constLabels),
+			"health_checks_passed":    newUpstreamServerMetric(namespace, "health_checks_last_passed", "Whether the last health check was successful and passed the tests", upstreamServerVariableLabelNames, constLabels),
+			"weight":                  newUpstreamServerMetric(namespace, "weight", "Weight of the server", upstreamServerVariableLabelNames, constLabels),
+			"backup":                  newUpstreamServerMetric(namespace, "backup", "Whether the server is a backup server", upstreamServerVariableLabelNames, constLabels),
+			"downtime":                newUpstreamServerMetric(namespace, "downtime_seconds_total", "Total time the server was in the 'unavail', 'checking' and 'unhealthy' states", upstreamServerVariableLabelNames, constLabels),
+			"downstart":               newUpstreamServerMetric(namespace, "downstart_timestamp_seconds", "Time when the server became 'unavail', 'checking' or 'unhealthy' (expressed as Unix Epoch Time)", upstreamServerVariableLabelNames, constLabels),
+			"selected":                newUpstreamServerMetric(namespace, "last_selected_timestamp_seconds", "Time when the server was last selected to process a request (expressed as Unix Epoch Time)", upstreamServerVariableLabelNames, constLabels),
 			"codes_100":               newUpstreamServerMetric(namespace, "responses_codes", "Total responses sent to clients", upstreamServerVariableLabelNames, MergeLabels(constLabels, prometheus.Labels{"code": "100"})),
 			"codes_101":               newUpstreamServerMetric(namespace, "responses_codes", "Total responses sent to clients", upstreamServerVariableLabelNames, MergeLabels(constLabels, prometheus.Labels{"code": "101"})),
 			"codes_102":               newUpstreamServerMetric(namespace, "responses_codes", "Total responses sent to clients", upstreamServerVariableLabelNames, MergeLabels(constLabels, prometheus.Labels{"code": "102"})),
@@ -441,6 +495,12 @@ func NewNginxPlusCollector(nginxClient *plusclient.NginxClient, namespace string
 			"health_checks_checks":    newStreamUpstreamServerMetric(namespace, "health_checks_checks", "Total health check requests", streamUpstreamServerVariableLabelNames, constLabels),
 			"health_checks_fails":     newStreamUpstreamServerMetric(namespace, "health_checks_fails", "Failed health checks", streamUpstreamServerVariableLabelNames, constLabels),
 			"health_checks_unhealthy": newStreamUpstreamServerMetric(namespace, "health_checks_unhealthy", "How many times the server became unhealthy (state 'unhealthy')", streamUpstreamServerVariableLabelNames, constLabels),
+			"health_checks_passed":    newStreamUpstreamServerMetric(namespace, "health_checks_last_passed", "Whether the last health check was successful and passed the tests", streamUpstreamServerVariableLabelNames, constLabels),
+			"weight":                  newStreamUpstreamServerMetric(namespace, "weight", "Weight of the server", streamUpstreamServerVariableLabelNames, constLabels),
+			"backup":                  newStreamUpstreamServerMetric(namespace, "backup", "Whether the server is a backup server", streamUpstreamServerVariableLabelNames, constLabels),
+			"downtime":                newStreamUpstreamServerMetric(namespace, "downtime_seconds_total", "Total time the server was in the 'unavail', 'checking' and 'unhealthy' states", streamUpstreamServerVariableLabelNames, constLabels),
+			"downstart":               newStreamUpstreamServerMetric(namespace, "downstart_timestamp_seconds", "Time when the server became 'unavail', 'checking' or 'unhealthy' (expressed as Unix Epoch Time)", streamUpstreamServerVariableLabelNames, constLabels),
+			"selected":                newStreamUpstreamServerMetric(namespace, "last_selected_timestamp_seconds", "Time when the server was last selected to process a connection (expressed as Unix Epoch Time)", streamUpstreamServerVariableLabelNames, constLabels),
 			"ssl_handshakes":          newStreamUpstreamServerMetric(namespace, "ssl_handshakes", "Successful SSL handshakes", streamUpstreamServerVariableLabelNames, constLabels),
 			"ssl_handshakes_failed":   newStreamUpstreamServerMetric(namespace, "ssl_handshakes_failed", "Failed SSL handshakes", streamUpstreamServerVariableLabelNames, constLabels),
 			"ssl_session_reuses":      newStreamUpstreamServerMetric(namespace, "ssl_session_reuses", "Session reuses during SSL handshake", streamUpstreamServerVariableLabelNames, constLabels),
@@ -535,7 +595,8 @@ func NewNginxPlusCollector(nginxClient *plusclient.NginxClient, namespace string
 			"rejected":         newStreamLimitConnectionMetric(namespace, "rejected", "Total number of connections that were rejected", constLabels),
 			"rejected_dry_run": newStreamLimitConnectionMetric(namespace, "rejected_dry_run", "Total number of connections accounted as rejected in the dry run mode", constLabels),
 		},
-		upMetric: newUpMetric(namespace, constLabels),
+		upMetric:    newUpMetric(namespace, constLabels),
+		errorMetric: newScrapeErrorMetric(namespace, constLabels),
 		cacheZoneMetrics: map[string]*prometheus.Desc{
 			"size":                      newCacheZoneMetric(namespace, "size", "Total size of the cache", variableLabelNames.CacheZoneVariableLabelNames, constLabels),
 			"max_size":                  newCacheZoneMetric(namespace, "max_size", "Maximum size of the cache", variableLabelNames.CacheZoneVariableLabelNames, constLabels),
@@ -567,13 +628,34 @@ func NewNginxPlusCollector(nginxClient *plusclient.NginxClient, namespace string
 			"http_requests_total":   newWorkerMetric(namespace, "http_requests_total", "The total number of client requests received by the worker process", constLabels),
 			"http_requests_current": newWorkerMetric(namespace, "http_requests_current", "The current number of client requests that are currently being processed by the worker process", constLabels),
 		},
+		slabMetrics: map[string]*prometheus.Desc{
+			"pages_used": newSlabMetric(namespace, "pages_used", "Number of used memory pages of the shared memory zone", constLabels),
+			"pages_free": newSlabMetric(namespace, "pages_free", "Number of free memory pages of the shared memory zone", constLabels),
+			"slot_used":  newSlabSlotMetric(namespace, "used", "Number of used memory slots of the slot size", constLabels),
+			"slot_free":  newSlabSlotMetric(namespace, "free", "Number of free memory slots of the slot size", constLabels),
+			"slot_reqs":  newSlabSlotMetric(namespace, "reqs", "Total number of attempts to allocate memory of the slot size", constLabels),
+			"slot_fails": newSlabSlotMetric(namespace, "fails", "Number of unsuccessful attempts to allocate memory of the slot size", constLabels),
+		},
+		keyvalMetrics: map[string]*prometheus.Desc{
+			"entries": newKeyvalMetric(namespace, "entries", "Number of key-value pairs in the zone", constLabels),
+			"value":   newKeyvalValueMetric(namespace, "value", "Numeric value of a selected key of the zone", constLabels),
+		},
+		streamKeyvalMetrics: map[string]*prometheus.Desc{
+			"entries": newStreamKeyvalMetric(namespace, "entries", "Number of key-value pairs in the zone", constLabels),
+			"value":   newStreamKeyvalValueMetric(namespace, "value", "Numeric value of a selected key of the zone", constLabels),
+		},
 	}
+	o := newOptions(opts)
+	c.keyvalKeys, c.streamKeyvalKeys, c.maxKeyvalKeys = o.keyvalKeys, o.streamKeyvalKeys, o.maxKeyvalKeys
+	c.stats = newStatsSource(o, c.fetchStats)
+	return c
 }
 
 // Describe sends the super-set of all possible descriptors of NGINX Plus metrics
 // to the provided channel.
 func (c *NginxPlusCollector) Describe(ch chan<- *prometheus.Desc) {
 	ch <- c.upMetric.Desc()
+	ch <- c.errorMetric
 
 	for _, m := range c.totalMetrics {
 		ch <- m
@@ -620,25 +702,53 @@ func (c *NginxPlusCollector) Describe(ch chan<- *prometheus.Desc) {
 	for _, m := range c.workerMetrics {
 		ch <- m
 	}
+	for _, m := range c.slabMetrics {
+		ch <- m
+	}
+	for _, m := range c.keyvalMetrics {
+		ch <- m
+	}
+	for _, m := range c.streamKeyvalMetrics {
+		ch <- m
+	}
 }
 
 // Collect fetches metrics from NGINX Plus and sends them to the provided channel.
 func (c *NginxPlusCollector) Collect(ch chan<- prometheus.Metric) {
+	if err := c.Scrape(context.Background(), ch); err != nil {
+		c.logger.Warn("error getting stats", "error", err.Error())
+	}
+}
+
+// Scrape fetches metrics from NGINX Plus and sends them to the provided channel. It
+// returns the error of a failed scrape, in which case only the up metric is sent, unless
+// the collector was created with WithStaleGracePeriod and the last successful scrape is
+// recent enough to send its metrics instead.
+func (c *NginxPlusCollector) Scrape(ctx context.Context, ch chan<- prometheus.Metric) error {
 	c.mutex.Lock() // To protect metrics from concurrent collects
 	defer c.mutex.Unlock()
 
-	// FIXME: https://github.com/nginx/nginx-prometheus-exporter/issues/858
-	stats, err := c.nginxClient.GetStats(context.TODO())
-	if err != nil {
+	snap := c.stats.get(ctx)
+	if snap.err != nil {
 		c.upMetric.Set(nginxDown)
-		ch <- c.upMetric
-		c.logger.Warn("error getting stats", "error", err.Error())
-		return
+	} else {
+		c.upMetric.Set(nginxUp)
 	}
-
-	c.upMetric.Set(nginxUp)
 	ch <- c.upMetric
 
+	if snap.err != nil {
+		ch <- prometheus.MustNewConstMetric(c.errorMetric,
+			prometheus.GaugeValue, 1, ClassifyScrapeError(snap.err))
+	}
+	if !snap.timestamp.IsZero() {
+		ch <- prometheus.MustNewConstMetric(c.totalMetrics["last_successful_scrape"],
+			prometheus.GaugeValue, float64(snap.timestamp.UnixNano())/1e9)
+	}
+	if !c.stats.servable(snap) {
+		return snap.err
+	}
+	stats, license := snap.stats.stats, snap.stats.license
+
 	ch <- prometheus.MustNewConstMetric(c.totalMetrics["connections_accepted"],
 		prometheus.CounterValue, float64(stats.Connections.Accepted))
 	ch <- prometheus.MustNewConstMetric(c.totalMetrics["connections_dropped"],
@@ -658,10 +768,25 @@ func (c *NginxPlusCollector) Collect(ch chan<- prometheus.Metric) {
 	ch <- prometheus.MustNewConstMetric(c.totalMetrics["ssl_session_reuses"],
 		prometheus.CounterValue, float64(stats.SSL.SessionReuses))
 
-	license, err := c.nginxClient.GetNginxLicense(context.TODO())
-	if err != nil {
-		c.logger.Warn("error getting license information", "error", err.Error())
+	ch <- prometheus.MustNewConstMetric(c.totalMetrics["info"],
+		prometheus.GaugeValue, 1, stats.NginxInfo.Version, stats.NginxInfo.Build, stats.NginxInfo.Address)
+	ch <- prometheus.MustNewConstMetric(c.totalMetrics["config_generation"],
+		prometheus.GaugeValue, float64(stats.NginxInfo.Generation))
+	if loadTimestamp, err := time.Parse(time.RFC3339, stats.NginxInfo.LoadTimestamp); err == nil {
+		ch <- prometheus.MustNewConstMetric(c.totalMetrics["load_timestamp"],
+			prometheus.GaugeValue, float64(loadTimestamp.UnixNano())/1e9)
 	} else {
+		c.logger.Debug("error parsing load timestamp", "load_timestamp", stats.NginxInfo.LoadTimestamp, "error", err.Error())
+	}
+	ch <- prometheus.MustNewConstMetric(c.totalMetrics["processes_respawned"],
+		prometheus.CounterValue, float64(stats.Processes.Respawned))
+
+	if c.stats.polling() {
+		ch <- prometheus.MustNewConstMetric(c.totalMetrics["snapshot_age"],
+			prometheus.GaugeValue, time.Since(snap.timestamp).Seconds())
+	}
+
+	if license != nil {
 		ch <- prometheus.MustNewConstMetric(c.totalMetrics["license_active_till"],
 			prometheus.GaugeValue, float64(license.ActiveTill))
 
@@ -901,6 +1026,22 @@ func (c *NginxPlusCollector) Collect(ch chan<- prometheus.Metric) {
 					prometheus.CounterValue, float64(peer.HealthChecks.Fails), labelValues...)
 				ch <- prometheus.MustNewConstMetric(c.upstreamServerMetrics["health_checks_unhealthy"],
 					prometheus.CounterValue, float64(peer.HealthChecks.Unhealthy), labelValues...)
+				ch <- prometheus.MustNewConstMetric(c.upstreamServerMetrics["health_checks_passed"],
+					prometheus.GaugeValue, booleanToFloat64[peer.HealthChecks.LastPassed], labelValues...)
+			}
+			ch <- prometheus.MustNewConstMetric(c.upstreamServerMetrics["weight"],
+				prometheus.GaugeValue, float64(peer.Weight), labelValues...)
+			ch <- prometheus.MustNewConstMetric(c.upstreamServerMetrics["backup"],
+				prometheus.GaugeValue, booleanToFloat64[peer.Backup], labelValues...)
+			ch <- prometheus.MustNewConstMetric(c.upstreamServerMetrics["downtime"],
+				prometheus.CounterValue, float64(peer.Downtime)/1000, labelValues...)
+			if downstart, ok := unixSeconds(peer.Downstart); ok {
+				ch <- prometheus.MustNewConstMetric(c.upstreamServerMetrics["downstart"],
+					prometheus.GaugeValue, downstart, labelValues...)
+			}
+			if selected, ok := unixSeconds(peer.Selected); ok {
+				ch <- prometheus.MustNewConstMetric(c.upstreamServerMetrics["selected"],
+					prometheus.GaugeValue, selected, labelValues...)
 			}
 			ch <- prometheus.MustNewConstMetric(c.upstreamServerMetrics["codes_100"],
 				prometheus.CounterValue, float64(peer.Responses.Codes.HTTPContinue), labelValues...)
@@ -993,6 +1134,16 @@ func (c *NginxPlusCollector) Collect(ch chan<- prometheus.Metric) {
 			prometheus.GaugeValue, float64(upstream.Keepalive), name)
 		ch <- prometheus.MustNewConstMetric(c.upstreamMetrics["zombies"],
 			prometheus.GaugeValue, float64(upstream.Zombies), name)
+
+		// the queue is only reported for upstreams with a queue, whose max_size is at least 1
+		if upstream.Queue.MaxSize > 0 {
+			ch <- prometheus.MustNewConstMetric(c.upstreamMetrics["queue_size"],
+				prometheus.GaugeValue, float64(upstream.Queue.Size), name)
+			ch <- prometheus.MustNewConstMetric(c.upstreamMetrics["queue_max_size"],
+				prometheus.GaugeValue, float64(upstream.Queue.MaxSize), name)
+			ch <- prometheus.MustNewConstMetric(c.upstreamMetrics["queue_overflows"],
+				prometheus.CounterValue, float64(upstream.Queue.Overflows), name)
+		}
 	}
 
 	for name, upstream := range stats.StreamUpstreams {
@@ -1049,6 +1200,22 @@ func (c *NginxPlusCollector) Collect(ch chan<- prometheus.Metric) {
 					prometheus.CounterValue, float64(peer.HealthChecks.Fails), labelValues...)
 				ch <- prometheus.MustNewConstMetric(c.streamUpstreamServerMetrics["health_checks_unhealthy"],
 					prometheus.CounterValue, float64(peer.HealthChecks.Unhealthy), labelValues...)
+				ch <- prometheus.MustNewConstMetric(c.streamUpstreamServerMetrics["health_checks_passed"],
+					prometheus.GaugeValue, booleanToFloat64[peer.HealthChecks.LastPassed], labelValues...)
+			}
+			ch <- prometheus.MustNewConstMetric(c.streamUpstreamServerMetrics["weight"],
+				prometheus.GaugeValue, float64(peer.Weight), labelValues...)
+			ch <- prometheus.MustNewConstMetric(c.streamUpstreamServerMetrics["backup"],
+				prometheus.GaugeValue, booleanToFloat64[peer.Backup], labelValues...)
+			ch <- prometheus.MustNewConstMetric(c.streamUpstreamServerMetrics["downtime"],
+				prometheus.CounterValue, float64(peer.Downtime)/1000, labelValues...)
+			if downstart, ok := unixSeconds(peer.Downstart); ok {
+				ch <- prometheus.MustNewConstMetric(c.streamUpstreamServerMetrics["downstart"],
+					prometheus.GaugeValue, downstart, labelValues...)
+			}
+			if selected, ok := unixSeconds(peer.Selected); ok {
+				ch <- prometheus.MustNewConstMetric(c.streamUpstreamServerMetrics["selected"],
+					prometheus.GaugeValue, selected, labelValues...)
 			}
 			ch <- prometheus.MustNewConstMetric(c.streamUpstreamServerMetrics["ssl_handshakes"],
 				prometheus.CounterValue, float64(peer.SSL.Handshakes), labelValues...)
@@ -1273,6 +1440,132 @@ func (c *NginxPlusCollector) Collect(ch chan<- prometheus.Metric) {
 		ch <- prometheus.MustNewConstMetric(c.workerMetrics["http_requests_total"], prometheus.CounterValue, float64(worker.HTTP.HTTPRequests.Total), workerID, workerPID)
 		ch <- prometheus.MustNewConstMetric(c.workerMetrics["http_requests_current"], prometheus.GaugeValue, float64(worker.HTTP.HTTPRequests.Current), workerID, workerPID)
 	}
+
+	for name, zone := range stats.Slabs {
+		ch <- prometheus.MustNewConstMetric(c.slabMetrics["pages_used"], prometheus.GaugeValue, float64(zone.Pages.Used), name)
+		ch <- prometheus.MustNewConstMetric(c.slabMetrics["pages_free"], prometheus.GaugeValue, float64(zone.Pages.Free), name)
+		for size, slot := range zone.Slots {
+			ch <- prometheus.MustNewConstMetric(c.slabMetrics["slot_used"], prometheus.GaugeValue, float64(slot.Used), name, size)
+			ch <- prometheus.MustNewConstMetric(c.slabMetrics["slot_free"], prometheus.GaugeValue, float64(slot.Free), name, size)
+			ch <- prometheus.MustNewConstMetric(c.slabMetrics["slot_reqs"], prometheus.CounterValue, float64(slot.Reqs), name, size)
+			ch <- prometheus.MustNewConstMetric(c.slabMetrics["slot_fails"], prometheus.CounterValue, float64(slot.Fails), name, size)
+		}
+	}
+
+	c.collectKeyvals(ch, c.keyvalMetrics, c.keyvalKeys, snap.stats.keyvals)
+	c.collectKeyvals(ch, c.streamKeyvalMetrics, c.streamKeyvalKeys, snap.stats.streamKeyvals)
+
+	return snap.err
+}
+
+// Run polls NGINX Plus in the background until ctx is canceled, if the collector was
+// created with WithPollInterval. Otherwise, it returns immediately.
+func (c *NginxPlusCollector) Run(ctx context.Context) {
+	c.stats.run(ctx)
+}
+
+// ScrapeErrors returns the number of failed requests for the stats of NGINX Plus by the class
+// of their error.
+func (c *NginxPlusCollector) ScrapeErrors() map[string]float64 {
+	return c.stats.errorCounts()
+}
+
+func (c *NginxPlusCollector) fetchStats(ctx context.Context) (plusStats, error) {
+	stats, err := c.nginxClient.GetStats(ctx)
+	if err != nil {
+		return plusStats{}, fmt.Errorf("failed to get stats: %w", err)
+	}
+
+	license, err := c.nginxClient.GetNginxLicense(ctx)
+	if err != nil {
+		c.logger.Warn("error getting license information", "error", err.Error())
+		license = nil
+	}
+
+	keyvals := c.fetchKeyvals(ctx, &c.keyvals, c.keyvalKeys, c.nginxClient.GetKeyValPairs, "keyvals")
+	streamKeyvals := c.fetchKeyvals(ctx, &c.streamKeyvals, c.streamKeyvalKeys, c.nginxClient.GetStreamKeyValPairs, "stream keyvals")
+
+	return plusStats{stats: stats, license: license, keyvals: keyvals, streamKeyvals: streamKeyvals}, nil
+}
+
+// fetchKeyvals fetches the key-value pairs of the zones of patterns with get, one request
+// per zone, so the pairs of other zones are never requested. The pairs of a zone that
+// cannot be fetched are taken from last instead, so their metrics do not disappear for a
+// single failed request. A 404 response means the zone does not exist. The fetched pairs
+// are stored in last.
+func (c *NginxPlusCollector) fetchKeyvals(ctx context.Context, last *plusclient.KeyValPairsByZone, patterns map[string]*regexp.Regexp,
+	get func(ctx context.Context, zone string) (plusclient.KeyValPairs, error), name string,
+) plusclient.KeyValPairsByZone {
+	if len(patterns) == 0 {
+		return nil
+	}
+
+	keyvals := make(plusclient.KeyValPairsByZone, len(patterns))
+	var failed []string
+	for zone := range patterns {
+		pairs, err := get(ctx, zone)
+		switch {
+		case err == nil:
+			keyvals[zone] = pairs
+		case isNotFound(err):
+		default:
+			c.logger.Warn("error getting "+name+", keeping the previous ones", "zone", zone, "error", err.Error())
+			failed = append(failed, zone)
+		}
+	}
+
+	c.keyvalsMutex.Lock()
+	defer c.keyvalsMutex.Unlock()
+
+	for _, zone := range failed {
+		if pairs, ok := (*last)[zone]; ok {
+			keyvals[zone] = pairs
+		}
+	}
+	*last = keyvals
+	return keyvals
+}
+
+// isNotFound returns true if err is a 404 response of the NGINX Plus API, which is
+// returned for a keyval zone that does not exist, or for every stream keyval zone when
+// NGINX has no stream block.
+func isNotFound(err error) bool {
+	var statusErr plusclient.StatusError
+	return errors.As(err, &statusErr) && statusErr.Status() == http.StatusNotFound
+}
+
+// collectKeyvals sends the number of key-value pairs of every fetched keyval zone, and
+// the numeric values of the keys matching the pattern of the zone, if it has one.
+func (c *NginxPlusCollector) collectKeyvals(ch chan<- prometheus.Metric, metrics map[string]*prometheus.Desc, patterns map[string]*regexp.Regexp, zones plusclient.KeyValPairsByZone) {
+	for zone, pairs := range zones {
+		ch <- prometheus.MustNewConstMetric(metrics["entries"], prometheus.GaugeValue, float64(len(pairs)), zone)
+
+		pattern := patterns[zone]
+		if pattern == nil {
+			continue
+		}
+		values := make(map[string]float64)
+		for key, value := range pairs {
+			if !pattern.MatchString(key) {
+				continue
+			}
+			v, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				c.logger.Debug("skipping keyval with a value that is not a number", "zone", zone, "key", key)
+				continue
+			}
+			values[key] = v
+		}
+
+		keys := slices.Sorted(maps.Keys(values))
+		if len(keys) > c.maxKeyvalKeys {
+			c.logger.Warn("too many keys of keyval zone selected, only the first ones are exported", "zone", zone, "keys", len(keys), "max_keys", c.maxKeyvalKeys)
+			keys = keys[:c.maxKeyvalKeys]
+		}
+		for _, key := range keys {
+			ch <- prometheus.MustNewConstMetric(metrics["value"], prometheus.GaugeValue, values[key], zone, key)
+		}
+	}
 }
 
 var upstreamServerStates = map[string]float64{
@@ -1289,6 +1582,20 @@ var booleanToFloat64 = map[bool]float64{
 	false: 0.0,
 }
 
+// unixSeconds returns the Unix time in seconds of a timestamp of the NGINX Plus API, and
+// false if the timestamp is empty, as for a peer that was never selected, or not valid.
+func unixSeconds(timestamp string) (float64, bool) {
+	t, err := time.Parse(time.RFC3339, timestamp)
+	if err != nil {
+		return 0, false
+	}
+	return float64(t.UnixNano()) / 1e9, true
+}
+
+func newInfoMetric(namespace string, constLabels prometheus.Labels) *prometheus.Desc {
+	return prometheus.NewDesc(namespace+"_info", "Information about the NGINX Plus instance", []string{"version", "build", "address"}, constLabels)
+}
+
 func newServerZoneMetric(namespace string, metricName string, docString string, variableLabelNames []string, constLabels prometheus.Labels) *prometheus.Desc {
 	labels := make([]string, 0, 1+len(variableLabelNames))
 	labels = append(labels, "server_zone")
@@ -1363,3 +1670,27 @@ func newCacheZoneMetric(namespace string, metricName string, docString string, v
 func newWorkerMetric(namespace string, metricName string, docString string, constLabels prometheus.Labels) *prometheus.Desc {
 	return prometheus.NewDesc(prometheus.BuildFQName(namespace, "worker", metricName), docString, []string{"id", "pid"}, constLabels)
 }
+
+func newSlabMetric(namespace string, metricName string, docString string, constLabels prometheus.Labels) *prometheus.Desc {
+	return prometheus.NewDesc(prometheus.BuildFQName(namespace, "slab", metricName), docString, []string{"zone"}, constLabels)
+}
+
+func newSlabSlotMetric(namespace string, metricName string, docString string, constLabels prometheus.Labels) *prometheus.Desc {
+	return prometheus.NewDesc(prometheus.BuildFQName(namespace, "slab_slot", metricName), docString, []string{"zone", "slot"}, constLabels)
+}
+
+func newKeyvalMetric(namespace string, metricName string, docString string, constLabels prometheus.Labels) *prometheus.Desc {
+	return prometheus.NewDesc(prometheus.BuildFQName(namespace, "keyval", metricName), docString, []string{"zone"}, constLabels)
+}
+
+func newKeyvalValueMetric(namespace string, metricName string, docString string, constLabels prometheus.Labels) *prometheus.Desc {
+	return prometheus.NewDesc(prometheus.BuildFQName(namespace, "keyval", metricName), docString, []string{"zone", "key"}, constLabels)
+}
+
+func newStreamKeyvalMetric(namespace string, metricName string, docString string, constLabels prometheus.Labels) *prometheus.Desc {
+	return prometheus.NewDesc(prometheus.BuildFQName(namespace, "stream_keyval", metricName), docString, []string{"zone"}, constLabels)
+}
+
+func newStreamKeyvalValueMetric(namespace string, metricName string, docString string, constLabels prometheus.Labels) *prometheus.Desc {
+	return prometheus.NewDesc(prometheus.BuildFQName(namespace, "stream_keyval", metricName), docString, []string{"zone", "key"}, constLabels)
+}
@@ -1,12 +1,102 @@
 package collector
 
 import (
+	"io"
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"slices"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 
+	plusclient "github.com/nginx/nginx-plus-go-client/v3/client"
 	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/prometheus/common/promslog"
 )
 
+// newTestPlusCollector returns a collector of a fake NGINX Plus API that responds with the
+// JSON of responses by the path after the API version, and with no stats otherwise.
+func newTestPlusCollector(t *testing.T, responses map[string]string, opts ...Option) *NginxPlusCollector {
+	t.Helper()
+
+	return newTestPlusCollectorWithHandler(t, newTestPlusHandler(responses), opts...)
+}
+
+// newTestPlusHandler returns the handler of the fake NGINX Plus API of
+// newTestPlusCollector.
+func newTestPlusHandler(responses map[string]string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path := strings.TrimPrefix(r.URL.Path, "/api/9/")
+		body, ok := responses[path]
+		switch {
+		case ok:
+		case path == "" || path == "stream" || path == "workers":
+			body = "[]"
+		default:
+			body = "{}"
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = io.WriteString(w, body)
+	})
+}
+
+// newTestPlusCollectorWithHandler returns a collector of the NGINX Plus API served by
+// handler.
+func newTestPlusCollectorWithHandler(t *testing.T, handler http.Handler, opts ...Option) *NginxPlusCollector {
+	t.Helper()
+
+	api := httptest.NewServer(handler)
+	t.Cleanup(api.Close)
+
+	nginxClient, err := plusclient.NewNginxClient(api.URL+"/api", plusclient.WithHTTPClient(api.Client()))
+	if err != nil {
+		t.Fatalf("NewNginxClient() returned error: %v", err)
+	}
+	return NewNginxPlusCollector(nginxClient, "nginxplus", VariableLabelNames{}, nil, promslog.NewNopLogger(), opts...)
+}
+
+func TestNginxPlusCollectorSlabs(t *testing.T) {
+	t.Parallel()
+
+	c := newTestPlusCollector(t, map[string]string{
+		"slabs": `{"ratelimit":{"pages":{"used":3,"free":61},"slots":{"8":{"used":2,"free":502,"reqs":74,"fails":0},"64":{"used":1,"free":63,"reqs":9,"fails":2}}}}`,
+	})
+
+	expected := `
+# HELP nginxplus_slab_pages_free Number of free memory pages of the shared memory zone
+# TYPE nginxplus_slab_pages_free gauge
+nginxplus_slab_pages_free{zone="ratelimit"} 61
+# HELP nginxplus_slab_pages_used Number of used memory pages of the shared memory zone
+# TYPE nginxplus_slab_pages_used gauge
+nginxplus_slab_pages_used{zone="ratelimit"} 3
+# HELP nginxplus_slab_slot_fails Number of unsuccessful attempts to allocate memory of the slot size
+# TYPE nginxplus_slab_slot_fails counter
+nginxplus_slab_slot_fails{slot="64",zone="ratelimit"} 2
+nginxplus_slab_slot_fails{slot="8",zone="ratelimit"} 0
+# HELP nginxplus_slab_slot_free Number of free memory slots of the slot size
+# TYPE nginxplus_slab_slot_free gauge
+nginxplus_slab_slot_free{slot="64",zone="ratelimit"} 63
+nginxplus_slab_slot_free{slot="8",zone="ratelimit"} 502
+# HELP nginxplus_slab_slot_reqs Total number of attempts to allocate memory of the slot size
+# TYPE nginxplus_slab_slot_reqs counter
+nginxplus_slab_slot_reqs{slot="64",zone="ratelimit"} 9
+nginxplus_slab_slot_reqs{slot="8",zone="ratelimit"} 74
+# HELP nginxplus_slab_slot_used Number of used memory slots of the slot size
+# TYPE nginxplus_slab_slot_used gauge
+nginxplus_slab_slot_used{slot="64",zone="ratelimit"} 1
+nginxplus_slab_slot_used{slot="8",zone="ratelimit"} 2
+`
+	if err := testutil.CollectAndCompare(c, strings.NewReader(expected),
+		"nginxplus_slab_pages_used", "nginxplus_slab_pages_free",
+		"nginxplus_slab_slot_used", "nginxplus_slab_slot_free", "nginxplus_slab_slot_reqs", "nginxplus_slab_slot_fails"); err != nil {
+		t.Error(err)
+	}
+}
+
 func TestNewMetricLabelPrealloc(t *testing.T) {
 	t.Parallel()
 
@@ -46,3 +136,230 @@ func TestNewMetricLabelPrealloc(t *testing.T) {
 		})
 	}
 }
+
+func TestNginxPlusCollectorInfo(t *testing.T) {
+	t.Parallel()
+
+	c := newTestPlusCollector(t, map[string]string{
+		"nginx":     `{"version":"1.27.4","build":"nginx-plus-r34","address":"10.0.0.1","generation":6,"load_timestamp":"2025-04-01T10:00:00.500Z","timestamp":"2025-04-01T10:05:00Z","pid":32,"ppid":1}`,
+		"processes": `{"respawned":2}`,
+	})
+
+	expected := `
+# HELP nginxplus_config_generation Total number of configuration reloads
+# TYPE nginxplus_config_generation gauge
+nginxplus_config_generation 6
+# HELP nginxplus_info Information about the NGINX Plus instance
+# TYPE nginxplus_info gauge
+nginxplus_info{address="10.0.0.1",build="nginx-plus-r34",version="1.27.4"} 1
+# HELP nginxplus_load_timestamp_seconds Time of the last reload of configuration (expressed as Unix Epoch Time)
+# TYPE nginxplus_load_timestamp_seconds gauge
+nginxplus_load_timestamp_seconds 1.7435016005e+09
+# HELP nginxplus_processes_respawned_total Total number of abnormally terminated and respawned child processes
+# TYPE nginxplus_processes_respawned_total counter
+nginxplus_processes_respawned_total 2
+`
+	if err := testutil.CollectAndCompare(c, strings.NewReader(expected),
+		"nginxplus_info", "nginxplus_config_generation", "nginxplus_load_timestamp_seconds", "nginxplus_processes_respawned_total"); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestNginxPlusCollectorKeyvals(t *testing.T) {
+	t.Parallel()
+
+	responses := map[string]string{
+		"http/keyvals/denylist": `{"10.0.0.3":"1","10.0.0.1":"5","10.0.0.2":"7"}`,
+		"http/keyvals/limits":   `{"10.0.0.1":"slow","total":"12","other":"1"}`,
+		"stream/keyvals/flags":  `{"tls13":"on","h3":"off"}`,
+		"stream/keyvals/limits": `{"10.0.0.1":"4","total":"3"}`,
+	}
+	// failing fails the requests of the keyvals, those of the stream module with a 404
+	// response as if NGINX had no stream block
+	var failing atomic.Bool
+	var mutex sync.Mutex
+	requested := make(map[string]bool)
+	handler := newTestPlusHandler(responses)
+	c := newTestPlusCollectorWithHandler(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.Contains(r.URL.Path, "/keyvals") {
+			mutex.Lock()
+			requested[strings.TrimPrefix(r.URL.Path, "/api/9/")] = true
+			mutex.Unlock()
+		}
+		switch {
+		case failing.Load() && strings.Contains(r.URL.Path, "/stream/keyvals"):
+			w.WriteHeader(http.StatusNotFound)
+			_, _ = io.WriteString(w, `{"error":{"status":404,"text":"path not found","code":"PathNotFound"}}`)
+		case failing.Load() && strings.Contains(r.URL.Path, "/http/keyvals"):
+			w.WriteHeader(http.StatusInternalServerError)
+			_, _ = io.WriteString(w, `{"error":{"status":500,"text":"internal error","code":"InternalError"}}`)
+		default:
+			handler.ServeHTTP(w, r)
+		}
+	}), WithKeyvalValues(
+		map[string]*regexp.Regexp{
+			"denylist": regexp.MustCompile(`^(?:10\..*)$`),
+			"limits":   regexp.MustCompile(`^(?:10\..*|total)$`),
+		},
+		map[string]*regexp.Regexp{
+			"flags":  nil,
+			"limits": regexp.MustCompile(`^(?:total)$`),
+		},
+		2,
+	))
+
+	// the values of denylist are capped to the first two keys, the value of limits that is
+	// not a number and the keys that are not selected are skipped, the HTTP and the stream
+	// zone named limits each have their own keys, and flags only has its number of entries
+	httpExpected := `
+# HELP nginxplus_keyval_entries Number of key-value pairs in the zone
+# TYPE nginxplus_keyval_entries gauge
+nginxplus_keyval_entries{zone="denylist"} 3
+nginxplus_keyval_entries{zone="limits"} 3
+# HELP nginxplus_keyval_value Numeric value of a selected key of the zone
+# TYPE nginxplus_keyval_value gauge
+nginxplus_keyval_value{key="10.0.0.1",zone="denylist"} 5
+nginxplus_keyval_value{key="10.0.0.2",zone="denylist"} 7
+nginxplus_keyval_value{key="total",zone="limits"} 12
+`
+	expected := httpExpected + `
+# HELP nginxplus_stream_keyval_entries Number of key-value pairs in the zone
+# TYPE nginxplus_stream_keyval_entries gauge
+nginxplus_stream_keyval_entries{zone="flags"} 2
+nginxplus_stream_keyval_entries{zone="limits"} 2
+# HELP nginxplus_stream_keyval_value Numeric value of a selected key of the zone
+# TYPE nginxplus_stream_keyval_value gauge
+nginxplus_stream_keyval_value{key="total",zone="limits"} 3
+`
+	metricNames := []string{"nginxplus_keyval_entries", "nginxplus_keyval_value", "nginxplus_stream_keyval_entries", "nginxplus_stream_keyval_value"}
+	if err := testutil.CollectAndCompare(c, strings.NewReader(expected), metricNames...); err != nil {
+		t.Error(err)
+	}
+
+	// only the selected zones are requested
+	mutex.Lock()
+	got := slices.Sorted(maps.Keys(requested))
+	mutex.Unlock()
+	want := []string{"http/keyvals/denylist", "http/keyvals/limits", "stream/keyvals/flags", "stream/keyvals/limits"}
+	if !slices.Equal(got, want) {
+		t.Errorf("requested keyvals = %v, want %v", got, want)
+	}
+
+	// the HTTP keyvals that cannot be fetched are kept, the stream keyvals that are not
+	// found are gone
+	failing.Store(true)
+	if err := testutil.CollectAndCompare(c, strings.NewReader(httpExpected), metricNames...); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestNginxPlusCollectorKeyvalsDisabled(t *testing.T) {
+	t.Parallel()
+
+	var requests atomic.Int64
+	handler := newTestPlusHandler(nil)
+	c := newTestPlusCollectorWithHandler(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.Contains(r.URL.Path, "/keyvals") {
+			requests.Add(1)
+		}
+		handler.ServeHTTP(w, r)
+	}))
+
+	if got := testutil.CollectAndCount(c, "nginxplus_keyval_entries", "nginxplus_stream_keyval_entries"); got != 0 {
+		t.Errorf("keyval entries count = %v, want 0", got)
+	}
+	if got := requests.Load(); got != 0 {
+		t.Errorf("NGINX Plus got %v keyval requests, want 0", got)
+	}
+}
+
+func TestNginxPlusCollectorUpstreamQueue(t *testing.T) {
+	t.Parallel()
+
+	c := newTestPlusCollector(t, map[string]string{
+		"http/upstreams": `{"queued":{"peers":[],"zone":"queued","queue":{"size":3,"max_size":100,"overflows":7}},"unqueued":{"peers":[],"zone":"unqueued"}}`,
+	})
+
+	expected := `
+# HELP nginxplus_upstream_queue_max_size Maximum number of requests that can be in the queue at the same time
+# TYPE nginxplus_upstream_queue_max_size gauge
+nginxplus_upstream_queue_max_size{upstream="queued"} 100
+# HELP nginxplus_upstream_queue_overflows_total Total number of requests rejected due to the queue overflow
+# TYPE nginxplus_upstream_queue_overflows_total counter
+nginxplus_upstream_queue_overflows_total{upstream="queued"} 7
+# HELP nginxplus_upstream_queue_size Current number of requests in the queue
+# TYPE nginxplus_upstream_queue_size gauge
+nginxplus_upstream_queue_size{upstream="queued"} 3
+`
+	if err := testutil.CollectAndCompare(c, strings.NewReader(expected),
+		"nginxplus_upstream_queue_size", "nginxplus_upstream_queue_max_size", "nginxplus_upstream_queue_overflows_total"); err != nil {
+		t.Error(err)
+	}
+	if got := testutil.CollectAndCount(c, "nginxplus_upstream_zombies"); got != 2 {
+		t.Errorf("nginxplus_upstream_zombies count = %v, want 2", got)
+	}
+}
+
+func TestNginxPlusCollectorUpstreamPeers(t *testing.T) {
+	t.Parallel()
+
+	c := newTestPlusCollector(t, map[string]string{
+		"http/upstreams": `{"backend":{"zone":"backend","peers":[
+			{"id":0,"server":"10.0.0.1:80","state":"up","weight":5,"backup":false,"downtime":0,"health_checks":{"checks":10,"fails":0,"unhealthy":0,"last_passed":true}},
+			{"id":1,"server":"10.0.0.2:80","state":"unavail","weight":1,"backup":true,"downtime":61500,"downstart":"2025-04-01T10:00:00.000Z","selected":"2025-04-01T09:59:58Z","health_checks":{"checks":10,"fails":3,"unhealthy":1,"last_passed":false}}
+		]}}`,
+		"":       `["nginx","http","stream"]`,
+		"stream": `["upstreams"]`,
+		"stream/upstreams": `{"dns":{"zone":"dns","peers":[
+			{"id":0,"server":"10.0.0.3:53","state":"up","weight":2,"backup":false,"downtime":1000,"selected":"2025-04-01T10:05:00Z"}
+		]}}`,
+	})
+
+	// the peer of backend that was never selected has no last selected time, and only the
+	// peer that is down has a downstart time
+	expected := `
+# HELP nginxplus_stream_upstream_server_backup Whether the server is a backup server
+# TYPE nginxplus_stream_upstream_server_backup gauge
+nginxplus_stream_upstream_server_backup{server="10.0.0.3:53",upstream="dns"} 0
+# HELP nginxplus_stream_upstream_server_downtime_seconds_total Total time the server was in the 'unavail', 'checking' and 'unhealthy' states
+# TYPE nginxplus_stream_upstream_server_downtime_seconds_total counter
+nginxplus_stream_upstream_server_downtime_seconds_total{server="10.0.0.3:53",upstream="dns"} 1
+# HELP nginxplus_stream_upstream_server_last_selected_timestamp_seconds Time when the server was last selected to process a connection (expressed as Unix Epoch Time)
+# TYPE nginxplus_stream_upstream_server_last_selected_timestamp_seconds gauge
+nginxplus_stream_upstream_server_last_selected_timestamp_seconds{server="10.0.0.3:53",upstream="dns"} 1.7435019e+09
+# HELP nginxplus_stream_upstream_server_weight Weight of the server
+# TYPE nginxplus_stream_upstream_server_weight gauge
+nginxplus_stream_upstream_server_weight{server="10.0.0.3:53",upstream="dns"} 2
+# HELP nginxplus_upstream_server_backup Whether the server is a backup server
+# TYPE nginxplus_upstream_server_backup gauge
+nginxplus_upstream_server_backup{server="10.0.0.1:80",upstream="backend"} 0
+nginxplus_upstream_server_backup{server="10.0.0.2:80",upstream="backend"} 1
+# HELP nginxplus_upstream_server_downstart_timestamp_seconds Time when the server became 'unavail', 'checking' or 'unhealthy' (expressed as Unix Epoch Time)
+# TYPE nginxplus_upstream_server_downstart_timestamp_seconds gauge
+nginxplus_upstream_server_downstart_timestamp_seconds{server="10.0.0.2:80",upstream="backend"} 1.7435016e+09
+# HELP nginxplus_upstream_server_downtime_seconds_total Total time the server was in the 'unavail', 'checking' and 'unhealthy' states
+# TYPE nginxplus_upstream_server_downtime_seconds_total counter
+nginxplus_upstream_server_downtime_seconds_total{server="10.0.0.1:80",upstream="backend"} 0
+nginxplus_upstream_server_downtime_seconds_total{server="10.0.0.2:80",upstream="backend"} 61.5
+# HELP nginxplus_upstream_server_health_checks_last_passed Whether the last health check was successful and passed the tests
+# TYPE nginxplus_upstream_server_health_checks_last_passed gauge
+nginxplus_upstream_server_health_checks_last_passed{server="10.0.0.1:80",upstream="backend"} 1
+nginxplus_upstream_server_health_checks_last_passed{server="10.0.0.2:80",upstream="backend"} 0
+# HELP nginxplus_upstream_server_last_selected_timestamp_seconds Time when the server was last selected to process a request (expressed as Unix Epoch Time)
+# TYPE nginxplus_upstream_server_last_selected_timestamp_seconds gauge
+nginxplus_upstream_server_last_selected_timestamp_seconds{server="10.0.0.2:80",upstream="backend"} 1.743501598e+09
+# HELP nginxplus_upstream_server_weight Weight of the server
+# TYPE nginxplus_upstream_server_weight gauge
+nginxplus_upstream_server_weight{server="10.0.0.1:80",upstream="backend"} 5
+nginxplus_upstream_server_weight{server="10.0.0.2:80",upstream="backend"} 1
+`
+	if err := testutil.CollectAndCompare(c, strings.NewReader(expected),
+		"nginxplus_upstream_server_weight", "nginxplus_upstream_server_backup", "nginxplus_upstream_server_downtime_seconds_total",
+		"nginxplus_upstream_server_downstart_timestamp_seconds", "nginxplus_upstream_server_last_selected_timestamp_seconds",
+		"nginxplus_upstream_server_health_checks_last_passed",
+		"nginxplus_stream_upstream_server_weight", "nginxplus_stream_upstream_server_backup", "nginxplus_stream_upstream_server_downtime_seconds_total",
+		"nginxplus_stream_upstream_server_downstart_timestamp_seconds", "nginxplus_stream_upstream_server_last_selected_timestamp_seconds",
+		"nginxplus_stream_upstream_server_health_checks_last_passed"); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,163 @@
+package collector
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/nginx/nginx-prometheus-exporter/client"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/prometheus/common/promslog"
+)
+
+const validStubStatus = "Active connections: 1457 \nserver accepts handled requests\n 6717066 6717066 65844359 \nReading: 1 Writing: 8 Waiting: 1448 \n"
+
+func TestNginxCollectorPolling(t *testing.T) {
+	t.Parallel()
+
+	var requests atomic.Int64
+	nginx := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		requests.Add(1)
+		_, _ = io.WriteString(w, validStubStatus)
+	}))
+	t.Cleanup(nginx.Close)
+
+	c := NewNginxCollector(client.NewNginxClient(nginx.Client(), nginx.URL), "nginx", nil, promslog.NewNopLogger(), WithPollInterval(time.Hour))
+
+	if err := testutil.CollectAndCompare(c, strings.NewReader("# HELP nginx_up Status of the last metric scrape\n# TYPE nginx_up gauge\nnginx_up 0\n"), "nginx_up"); err != nil {
+		t.Errorf("before the first poll: %v", err)
+	}
+
+	go c.Run(t.Context())
+	deadline := time.Now().Add(5 * time.Second)
+	for c.stats.get(t.Context()).err != nil && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	for range 3 {
+		if err := testutil.CollectAndCompare(c, strings.NewReader("# HELP nginx_connections_active Active client connections\n# TYPE nginx_connections_active gauge\nnginx_connections_active 1457\n"), "nginx_connections_active"); err != nil {
+			t.Error(err)
+		}
+	}
+	if got := testutil.CollectAndCount(c, "nginx_snapshot_age_seconds"); got != 1 {
+		t.Errorf("nginx_snapshot_age_seconds count = %v, want 1", got)
+	}
+	if got := requests.Load(); got != 1 {
+		t.Errorf("NGINX got %v requests, want 1", got)
+	}
+}
+
+func TestNginxCollectorWithoutPolling(t *testing.T) {
+	t.Parallel()
+
+	var requests atomic.Int64
+	nginx := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		requests.Add(1)
+		_, _ = io.WriteString(w, validStubStatus)
+	}))
+	t.Cleanup(nginx.Close)
+
+	c := NewNginxCollector(client.NewNginxClient(nginx.Client(), nginx.URL), "nginx", nil, promslog.NewNopLogger())
+
+	for range 2 {
+		if got := testutil.CollectAndCount(c, "nginx_connections_active", "nginx_snapshot_age_seconds"); got != 1 {
+			t.Errorf("metric count = %v, want 1", got)
+		}
+	}
+	if got := requests.Load(); got != 2 {
+		t.Errorf("NGINX got %v requests, want 2", got)
+	}
+}
+
+func TestNginxCollectorStaleGracePeriod(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name             string
+		staleGracePeriod time.Duration
+		wantStale        int
+	}{
+		{
+			name:      "disabled",
+			wantStale: 0,
+		},
+		{
+			name:             "within the grace period",
+			staleGracePeriod: time.Hour,
+			wantStale:        1,
+		},
+		{
+			name:             "after the grace period",
+			staleGracePeriod: time.Nanosecond,
+			wantStale:        0,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			var failing atomic.Bool
+			nginx := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+				if failing.Load() {
+					w.WriteHeader(http.StatusBadGateway)
+					return
+				}
+				_, _ = io.WriteString(w, validStubStatus)
+			}))
+			t.Cleanup(nginx.Close)
+
+			c := NewNginxCollector(client.NewNginxClient(nginx.Client(), nginx.URL), "nginx", nil, promslog.NewNopLogger(), WithStaleGracePeriod(tt.staleGracePeriod))
+			if err := c.Scrape(t.Context(), make(chan prometheus.Metric, 16)); err != nil {
+				t.Fatalf("Scrape() returned error: %v", err)
+			}
+			time.Sleep(time.Millisecond)
+
+			failing.Store(true)
+			if err := c.Scrape(t.Context(), make(chan prometheus.Metric, 16)); err == nil {
+				t.Error("Scrape() of an unavailable NGINX returned no error")
+			}
+
+			if err := testutil.CollectAndCompare(c, strings.NewReader("# HELP nginx_up Status of the last metric scrape\n# TYPE nginx_up gauge\nnginx_up 0\n"), "nginx_up"); err != nil {
+				t.Error(err)
+			}
+			if got := testutil.CollectAndCount(c, "nginx_connections_active"); got != tt.wantStale {
+				t.Errorf("nginx_connections_active count = %v, want %v", got, tt.wantStale)
+			}
+			if got := testutil.CollectAndCount(c, "nginx_last_successful_scrape_timestamp_seconds"); got != 1 {
+				t.Errorf("nginx_last_successful_scrape_timestamp_seconds count = %v, want 1", got)
+			}
+		})
+	}
+}
+
+func TestNginxCollectorScrapeErrorInfo(t *testing.T) {
+	t.Parallel()
+
+	var forbidden atomic.Bool
+	nginx := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		if forbidden.Load() {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		_, _ = io.WriteString(w, validStubStatus)
+	}))
+	t.Cleanup(nginx.Close)
+
+	c := NewNginxCollector(client.NewNginxClient(nginx.Client(), nginx.URL), "nginx", nil, promslog.NewNopLogger())
+
+	if got := testutil.CollectAndCount(c, "nginx_scrape_error_info"); got != 0 {
+		t.Errorf("nginx_scrape_error_info count of a successful scrape = %v, want 0", got)
+	}
+
+	forbidden.Store(true)
+	want := "# HELP nginx_scrape_error_info Class of the error of the last metric scrape, only exported when it failed\n# TYPE nginx_scrape_error_info gauge\nnginx_scrape_error_info{reason=\"http_status\"} 1\n"
+	if err := testutil.CollectAndCompare(c, strings.NewReader(want), "nginx_scrape_error_info"); err != nil {
+		t.Error(err)
+	}
+}
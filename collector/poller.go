@@ -0,0 +1,157 @@
+package collector
+
+import (
+	"context"
+	"errors"
+	"maps"
+	"regexp"
+	"sync"
+	"time"
+)
+
+var errNoSnapshot = errors.New("no stats polled yet")
+
+// Option configures a collector.
+type Option func(*options)
+
+type options struct {
+	keyvalKeys       map[string]*regexp.Regexp
+	streamKeyvalKeys map[string]*regexp.Regexp
+	pollInterval     time.Duration
+	staleGracePeriod time.Duration
+	maxKeyvalKeys    int
+}
+
+// WithPollInterval makes the collector poll NGINX every interval in the background, once
+// its Run method is called, and serve the metrics of the last polled snapshot. This way
+// the load on NGINX does not depend on the number of scrapes of the collector. A zero
+// interval disables polling, so NGINX is requested on every scrape.
+func WithPollInterval(interval time.Duration) Option {
+	return func(o *options) {
+		o.pollInterval = interval
+	}
+}
+
+// WithStaleGracePeriod makes the collector serve the metrics of the last successful
+// scrape when a scrape fails, as long as they are not older than gracePeriod. The up
+// metric still reports the failed scrape. A zero grace period disables serving stale
+// metrics.
+func WithStaleGracePeriod(gracePeriod time.Duration) Option {
+	return func(o *options) {
+		o.staleGracePeriod = gracePeriod
+	}
+}
+
+func newOptions(opts []Option) options {
+	var o options
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+// snapshot is the result of fetching the stats of NGINX.
+type snapshot[T any] struct {
+	// timestamp is the time of the last successful fetch, zero if there was none.
+	timestamp time.Time
+	// err is the error of the last fetch, if it failed.
+	err error
+	// stats are the stats of the last successful fetch.
+	stats T
+}
+
+// statsSource fetches the stats of NGINX, either on every scrape or in the background,
+// and keeps the stats of the last successful fetch and the number of failed fetches.
+type statsSource[T any] struct {
+	fetch            func(ctx context.Context) (T, error)
+	errors           map[string]float64
+	last             snapshot[T]
+	pollInterval     time.Duration
+	staleGracePeriod time.Duration
+	mutex            sync.RWMutex
+}
+
+func newStatsSource[T any](o options, fetch func(ctx context.Context) (T, error)) *statsSource[T] {
+	s := &statsSource[T]{
+		fetch:            fetch,
+		errors:           make(map[string]float64),
+		pollInterval:     o.pollInterval,
+		staleGracePeriod: o.staleGracePeriod,
+	}
+	if s.polling() {
+		s.last.err = errNoSnapshot
+	}
+	return s
+}
+
+func (s *statsSource[T]) polling() bool {
+	return s.pollInterval > 0
+}
+
+// run polls every poll interval until ctx is canceled, if polling is enabled. A poll
+// that takes longer than the interval is canceled, so polls never overlap.
+func (s *statsSource[T]) run(ctx context.Context) {
+	if !s.polling() {
+		return
+	}
+
+	ticker := time.NewTicker(s.pollInterval)
+	defer ticker.Stop()
+
+	for {
+		pollCtx, cancel := context.WithTimeout(ctx, s.pollInterval)
+		s.update(s.fetch(pollCtx))
+		cancel()
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (s *statsSource[T]) update(stats T, err error) snapshot[T] {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.last.err = err
+	if err == nil {
+		s.last.stats = stats
+		s.last.timestamp = time.Now()
+	} else {
+		s.errors[ClassifyScrapeError(err)]++
+	}
+	return s.last
+}
+
+// errorCounts returns the number of failed fetches by the class of their error. A failed
+// poll is counted once, no matter how often its snapshot is scraped.
+func (s *statsSource[T]) errorCounts() map[string]float64 {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	return maps.Clone(s.errors)
+}
+
+// get returns the last polled snapshot if polling is enabled. Otherwise, it fetches the
+// stats and returns the resulting snapshot.
+func (s *statsSource[T]) get(ctx context.Context) snapshot[T] {
+	if !s.polling() {
+		return s.update(s.fetch(ctx))
+	}
+
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	return s.last
+}
+
+// servable reports whether the stats of snap can be served: the last fetch was
+// successful, or the stats are within the stale grace period.
+func (s *statsSource[T]) servable(snap snapshot[T]) bool {
+	if snap.err == nil {
+		return true
+	}
+	return s.staleGracePeriod > 0 && !snap.timestamp.IsZero() && time.Since(snap.timestamp) <= s.staleGracePeriod
+}
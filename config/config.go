@@ -0,0 +1,795 @@
+package config
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/netip"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/prometheus/common/model"
+	yaml "go.yaml.in/yaml/v2"
+)
+
+const (
+	// ModeOSS scrapes the stub_status page of NGINX.
+	ModeOSS = "oss"
+	// ModePlus scrapes the API of NGINX Plus.
+	ModePlus = "plus"
+
+	// DefaultTimeout is the scrape timeout used when a module does not set one.
+	DefaultTimeout = 5 * time.Second
+	// DefaultRetryInitialBackoff is the wait before the first retry of a request when a
+	// module enables retries without setting one.
+	DefaultRetryInitialBackoff = 100 * time.Millisecond
+	// DefaultRetryMaxBackoff is the longest wait between two retries of a request when a
+	// module enables retries without setting one.
+	DefaultRetryMaxBackoff = time.Second
+	// DefaultKeyvalMaxKeysPerZone is the maximum number of exported keys of a key-value
+	// store zone when a module exports keyval values without setting one.
+	DefaultKeyvalMaxKeysPerZone = 100
+	// DNSRecordTypeSRV discovers targets from SRV records.
+	DNSRecordTypeSRV = "SRV"
+	// DNSRecordTypeA discovers targets from A records.
+	DNSRecordTypeA = "A"
+	// DNSRecordTypeAAAA discovers targets from AAAA records.
+	DNSRecordTypeAAAA = "AAAA"
+
+	// DefaultRefreshInterval is the interval used by service discovery when it does not set one.
+	DefaultRefreshInterval = 30 * time.Second
+)
+
+// Config is the configuration file of the exporter.
+type Config struct {
+	Modules             map[string]Module    `yaml:"modules,omitempty"`
+	Targets             []Target             `yaml:"targets,omitempty"`
+	FileSDConfigs       []FileSDConfig       `yaml:"file_sd_configs,omitempty"`
+	KubernetesSDConfigs []KubernetesSDConfig `yaml:"kubernetes_sd_configs,omitempty"`
+	DNSSDConfigs        []DNSSDConfig        `yaml:"dns_sd_configs,omitempty"`
+}
+
+// HasDiscovery reports whether any service discovery is configured.
+func (c *Config) HasDiscovery() bool {
+	return len(c.FileSDConfigs) > 0 || len(c.KubernetesSDConfigs) > 0 || len(c.DNSSDConfigs) > 0
+}
+
+// FileSDConfig discovers targets from files in the Prometheus file_sd format.
+type FileSDConfig struct {
+	// Files are the paths of the files, which may contain glob patterns.
+	Files           []string `yaml:"files"`
+	TargetTemplate  `yaml:",inline"`
+	RefreshInterval time.Duration `yaml:"refresh_interval,omitempty"`
+}
+
+// KubernetesSDConfig discovers the pods of NGINX and NGINX Plus through the Kubernetes API.
+type KubernetesSDConfig struct {
+	// APIServer is the URL of the Kubernetes API server. If empty, the exporter is assumed
+	// to run in a pod and uses the in-cluster API server and service account.
+	APIServer string `yaml:"api_server,omitempty"`
+	// APIServerBearerTokenFile is the file with the token used to authenticate to the API
+	// server.
+	APIServerBearerTokenFile string `yaml:"api_server_bearer_token_file,omitempty"`
+	// LabelSelector restricts the watched pods.
+	LabelSelector string `yaml:"label_selector,omitempty"`
+	// Namespaces are the watched namespaces, all namespaces if empty.
+	Namespaces []string `yaml:"namespaces,omitempty"`
+	// PodLabels are the pod labels added to the metrics of the discovered targets.
+	PodLabels          []string  `yaml:"pod_labels,omitempty"`
+	APIServerTLSConfig TLSConfig `yaml:"api_server_tls_config,omitempty"`
+	TargetTemplate     `yaml:",inline"`
+}
+
+// DNSSDConfig discovers targets by resolving DNS names.
+type DNSSDConfig struct {
+	Names []string `yaml:"names"`
+	// Type is the type of the DNS records, SRV, A or AAAA.
+	Type            string `yaml:"type,omitempty"`
+	TargetTemplate  `yaml:",inline"`
+	RefreshInterval time.Duration `yaml:"refresh_interval,omitempty"`
+	// Port is the port of the targets found by A and AAAA records.
+	Port uint16 `yaml:"port,omitempty"`
+}
+
+// TargetTemplate holds the settings of the targets found by service discovery.
+type TargetTemplate struct {
+	// Labels are added as const labels to all metrics of the discovered targets.
+	Labels map[string]string `yaml:"labels,omitempty"`
+	// Scheme is the scheme of the scrape URI, http or https.
+	Scheme string `yaml:"scheme,omitempty"`
+	// Path is the request path of the scrape URI. It defaults to /stub_status for
+	// NGINX and to /api for NGINX Plus.
+	Path   string `yaml:"path,omitempty"`
+	Module `yaml:",inline"`
+}
+
+// Target is a single NGINX or NGINX Plus instance scraped by the exporter.
+type Target struct {
+	// Labels are added as const labels to all metrics of the target.
+	Labels map[string]string `yaml:"labels,omitempty"`
+	// Name identifies the target in logs and defaults to URI.
+	Name   string `yaml:"name,omitempty"`
+	URI    string `yaml:"uri"`
+	Module `yaml:",inline"`
+}
+
+// Module holds the settings used to scrape a single NGINX or NGINX Plus instance.
+type Module struct {
+	Headers      map[string]string `yaml:"headers,omitempty"`
+	BasicAuth    *BasicAuth        `yaml:"basic_auth,omitempty"`
+	OAuth2       *OAuth2           `yaml:"oauth2,omitempty"`
+	KeyvalValues KeyvalValues      `yaml:"keyval_values,omitempty"`
+	// BearerTokenFile is read again whenever it changes, so the token can be rotated
+	// without a reload.
+	BearerTokenFile string `yaml:"bearer_token_file,omitempty"`
+	Mode            string `yaml:"mode,omitempty"`
+	// ProxyURL is the URL of the HTTP, HTTPS or SOCKS5 proxy used to connect to NGINX.
+	ProxyURL  string    `yaml:"proxy_url,omitempty"`
+	TLSConfig TLSConfig `yaml:"tls_config,omitempty"`
+	// ProbeAllowedHosts are the regular expressions of the hosts that the /probe endpoint
+	// scrapes with a module that has credentials. A host is allowed if one of them matches
+	// the whole host name of the target. It is ignored by the scrape targets.
+	ProbeAllowedHosts []string      `yaml:"probe_allowed_hosts,omitempty"`
+	ProxyProtocol     ProxyProtocol `yaml:"proxy_protocol,omitempty"`
+	Retry             Retry         `yaml:"retry,omitempty"`
+	Timeout           time.Duration `yaml:"timeout,omitempty"`
+	// PollInterval enables polling NGINX in the background, so scrapes are served from
+	// the last polled stats. It is ignored by the /probe endpoint.
+	PollInterval time.Duration `yaml:"poll_interval,omitempty"`
+	// StaleGracePeriod enables serving the metrics of the last successful scrape for this
+	// long when a scrape fails. It is ignored by the /probe endpoint.
+	StaleGracePeriod time.Duration `yaml:"stale_grace_period,omitempty"`
+}
+
+// KeyvalValues selects the key-value store zones of NGINX Plus whose number of entries is
+// exported as a metric, and the keys of the zones whose numeric values are exported as
+// metrics. Only the selected zones are fetched from NGINX Plus. It is ignored for NGINX.
+type KeyvalValues struct {
+	// HTTPZones maps the name of an HTTP keyval zone to the regular expressions of its
+	// exported keys. A key is exported if one of them matches the whole key. A zone
+	// without regular expressions only exports its number of entries.
+	HTTPZones map[string][]string `yaml:"http_zones,omitempty"`
+	// StreamZones is like HTTPZones for the keyval zones of the stream module.
+	StreamZones map[string][]string `yaml:"stream_zones,omitempty"`
+	// MaxKeysPerZone is the maximum number of exported keys of a zone, the first ones in
+	// lexical order.
+	MaxKeysPerZone int `yaml:"max_keys_per_zone,omitempty"`
+}
+
+// KeyPatterns returns the regular expressions matching the exported keys of every HTTP
+// and every stream zone, nil for a zone without exported keys.
+func (k *KeyvalValues) KeyPatterns() (httpKeys, streamKeys map[string]*regexp.Regexp, err error) {
+	if httpKeys, err = keyPatterns("http_zones", k.HTTPZones); err != nil {
+		return nil, nil, err
+	}
+	if streamKeys, err = keyPatterns("stream_zones", k.StreamZones); err != nil {
+		return nil, nil, err
+	}
+	return httpKeys, streamKeys, nil
+}
+
+// keyPatterns returns the regular expression matching the exported keys of every zone
+// of the field name.
+func keyPatterns(name string, zones map[string][]string) (map[string]*regexp.Regexp, error) {
+	patterns := make(map[string]*regexp.Regexp, len(zones))
+	for zone, keys := range zones {
+		if len(keys) == 0 {
+			patterns[zone] = nil
+			continue
+		}
+		for _, key := range keys {
+			if _, err := regexp.Compile(key); err != nil {
+				return nil, fmt.Errorf("keyval_values: %s: invalid key %q of zone %q: %w", name, key, zone, err)
+			}
+		}
+		patterns[zone] = regexp.MustCompile("^(?:" + strings.Join(keys, "|") + ")$")
+	}
+	return patterns, nil
+}
+
+// Validate checks the keyval values settings.
+func (k *KeyvalValues) Validate() error {
+	if k.MaxKeysPerZone < 0 {
+		return fmt.Errorf("keyval_values: negative max_keys_per_zone %d is not valid", k.MaxKeysPerZone)
+	}
+	_, _, err := k.KeyPatterns()
+	return err
+}
+
+// Retry configures retries of the requests to NGINX or NGINX Plus that fail with a
+// network error or a 5xx response. The wait between the attempts of a request doubles
+// from InitialBackoff up to MaxBackoff, and no attempt is made after the scrape timeout.
+type Retry struct {
+	// Attempts is the maximum number of attempts of a request, including the first one.
+	// Requests are not retried if it is 0 or 1.
+	Attempts       int           `yaml:"attempts,omitempty"`
+	InitialBackoff time.Duration `yaml:"initial_backoff,omitempty"`
+	MaxBackoff     time.Duration `yaml:"max_backoff,omitempty"`
+}
+
+// ProxyProtocol configures the PROXY protocol header sent at the start of every
+// connection to NGINX. In the configuration file, it is either true to send a version 2
+// header with the addresses of the connection, or a mapping with the settings of the
+// header.
+type ProxyProtocol struct {
+	TLVs ProxyProtocolTLVs `yaml:"tlvs,omitempty"`
+	// SourceAddress replaces the source address of the connection in the header. It is
+	// an IP address, optionally with a port, for example to match the set_real_ip_from
+	// addresses of NGINX.
+	SourceAddress string `yaml:"source_address,omitempty"`
+	// Version of the header, 1 (text) or 2 (binary), defaults to 2.
+	Version int  `yaml:"version,omitempty"`
+	Enabled bool `yaml:"enabled,omitempty"`
+}
+
+// ProxyProtocolTLVs are the TLVs added to a version 2 PROXY protocol header.
+type ProxyProtocolTLVs struct {
+	// Authority is the host name requested by the client, PP2_TYPE_AUTHORITY.
+	Authority string `yaml:"authority,omitempty"`
+	// ALPN is the application protocol of the connection, PP2_TYPE_ALPN.
+	ALPN string `yaml:"alpn,omitempty"`
+	// UniqueID identifies the connection, PP2_TYPE_UNIQUE_ID.
+	UniqueID string `yaml:"unique_id,omitempty"`
+}
+
+// UnmarshalYAML implements the yaml.Unmarshaler interface. It accepts a boolean as
+// well as a mapping, which enables the PROXY protocol unless enabled is false.
+func (p *ProxyProtocol) UnmarshalYAML(unmarshal func(any) error) error {
+	var enabled bool
+	if err := unmarshal(&enabled); err == nil {
+		*p = ProxyProtocol{Enabled: enabled}
+		return nil
+	}
+
+	type plain ProxyProtocol
+	settings := plain{Enabled: true}
+	if err := unmarshal(&settings); err != nil {
+		return err
+	}
+	*p = ProxyProtocol(settings)
+	return nil
+}
+
+// Validate checks the PROXY protocol settings.
+func (p *ProxyProtocol) Validate() error {
+	if !p.Enabled {
+		return nil
+	}
+	if p.Version != 1 && p.Version != 2 {
+		return fmt.Errorf("proxy_protocol: invalid version %d, must be 1 or 2", p.Version)
+	}
+	if p.SourceAddress != "" {
+		if _, err := netip.ParseAddr(p.SourceAddress); err != nil {
+			if _, err := netip.ParseAddrPort(p.SourceAddress); err != nil {
+				return fmt.Errorf("proxy_protocol: source_address %q must be an IP address, optionally with a port", p.SourceAddress)
+			}
+		}
+	}
+	if p.TLVs != (ProxyProtocolTLVs{}) && p.Version != 2 {
+		return errors.New("proxy_protocol: tlvs require version 2")
+	}
+	if len(p.TLVs.UniqueID) > 128 {
+		return errors.New("proxy_protocol: unique_id must not be longer than 128 bytes")
+	}
+	return nil
+}
+
+// Validate checks the retry settings.
+func (r *Retry) Validate() error {
+	if r.Attempts < 0 {
+		return fmt.Errorf("retry: negative attempts %d is not valid", r.Attempts)
+	}
+	if r.InitialBackoff < 0 || r.MaxBackoff < 0 {
+		return errors.New("retry: negative backoffs are not valid")
+	}
+	if r.MaxBackoff != 0 && r.MaxBackoff < r.InitialBackoff {
+		return fmt.Errorf("retry: max_backoff %v is shorter than initial_backoff %v", r.MaxBackoff, r.InitialBackoff)
+	}
+	return nil
+}
+
+// BasicAuth configures the HTTP basic authentication of the requests to NGINX or NGINX
+// Plus. The password is either given inline or read from PasswordFile, which is read
+// again whenever it changes.
+type BasicAuth struct {
+	Username     string `yaml:"username"`
+	Password     string `yaml:"password,omitempty"`
+	PasswordFile string `yaml:"password_file,omitempty"`
+}
+
+// OAuth2 configures the OAuth2 client credentials flow used to get the access tokens
+// of the requests to NGINX or NGINX Plus, for example when the NGINX Plus API is behind
+// a gateway that requires OAuth2. The client secret is either given inline or read from
+// ClientSecretFile, which is read again whenever it changes.
+type OAuth2 struct {
+	EndpointParams   map[string]string `yaml:"endpoint_params,omitempty"`
+	ClientID         string            `yaml:"client_id"`
+	ClientSecret     string            `yaml:"client_secret,omitempty"`
+	ClientSecretFile string            `yaml:"client_secret_file,omitempty"`
+	TokenURL         string            `yaml:"token_url"`
+	// ProxyURL is the URL of the HTTP, HTTPS or SOCKS5 proxy used to connect to the
+	// token endpoint.
+	ProxyURL string   `yaml:"proxy_url,omitempty"`
+	Scopes   []string `yaml:"scopes,omitempty"`
+	// TLSConfig configures the TLS connection to the token endpoint.
+	TLSConfig TLSConfig `yaml:"tls_config,omitempty"`
+}
+
+// TLSConfig configures the TLS connection to NGINX or NGINX Plus.
+type TLSConfig struct {
+	CAFile   string `yaml:"ca_file,omitempty"`
+	CertFile string `yaml:"cert_file,omitempty"`
+	KeyFile  string `yaml:"key_file,omitempty"`
+	// PKCS12File is a PKCS#12 bundle with the client certificate and key, as an
+	// alternative to CertFile and KeyFile. It is decrypted with PKCS12Password or the
+	// password in PKCS12PasswordFile.
+	PKCS12File         string `yaml:"pkcs12_file,omitempty"`
+	PKCS12Password     string `yaml:"pkcs12_password,omitempty"`
+	PKCS12PasswordFile string `yaml:"pkcs12_password_file,omitempty"`
+	// ServerName is the name sent with SNI and expected in the certificate of the
+	// server, instead of the host of the scrape URI, for example when scraping by IP.
+	ServerName string `yaml:"server_name,omitempty"`
+	// CipherSuites are the TLS 1.0-1.2 cipher suites offered to the server, by their
+	// names in the crypto/tls package. TLS 1.3 cipher suites are not configurable.
+	CipherSuites []CipherSuite `yaml:"cipher_suites,omitempty"`
+	// PinnedSPKISHA256 are the base64 encoded SHA-256 hashes of the public keys
+	// (SubjectPublicKeyInfo) that are trusted. If set, a certificate of the server's
+	// chain must have one of these public keys.
+	PinnedSPKISHA256   []string   `yaml:"pinned_spki_sha256,omitempty"`
+	MinVersion         TLSVersion `yaml:"min_version,omitempty"`
+	InsecureSkipVerify bool       `yaml:"insecure_skip_verify,omitempty"`
+}
+
+// TLSVersion is a TLS version, TLS10, TLS11, TLS12 or TLS13 in the configuration file.
+type TLSVersion uint16
+
+var tlsVersions = map[string]TLSVersion{
+	"TLS10": tls.VersionTLS10,
+	"TLS11": tls.VersionTLS11,
+	"TLS12": tls.VersionTLS12,
+	"TLS13": tls.VersionTLS13,
+}
+
+// UnmarshalYAML implements the yaml.Unmarshaler interface.
+func (v *TLSVersion) UnmarshalYAML(unmarshal func(any) error) error {
+	var name string
+	if err := unmarshal(&name); err != nil {
+		return err
+	}
+	version, ok := tlsVersions[name]
+	if !ok {
+		return fmt.Errorf("unknown TLS version %q, must be TLS10, TLS11, TLS12 or TLS13", name)
+	}
+	*v = version
+	return nil
+}
+
+// CipherSuite is the ID of a TLS cipher suite, given by its name in the configuration
+// file, for example TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256.
+type CipherSuite uint16
+
+// UnmarshalYAML implements the yaml.Unmarshaler interface.
+func (c *CipherSuite) UnmarshalYAML(unmarshal func(any) error) error {
+	var name string
+	if err := unmarshal(&name); err != nil {
+		return err
+	}
+	for _, suite := range append(tls.CipherSuites(), tls.InsecureCipherSuites()...) {
+		if suite.Name == name {
+			*c = CipherSuite(suite.ID)
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown cipher suite %q", name)
+}
+
+// Validate checks the TLS settings.
+func (c *TLSConfig) Validate() error {
+	if (c.CertFile == "") != (c.KeyFile == "") {
+		return errors.New("cert_file and key_file must be set together")
+	}
+	if c.PKCS12File != "" && c.CertFile != "" {
+		return errors.New("at most one of pkcs12_file and cert_file must be set")
+	}
+	if c.PKCS12Password != "" && c.PKCS12PasswordFile != "" {
+		return errors.New("at most one of pkcs12_password and pkcs12_password_file must be set")
+	}
+	if c.PKCS12File == "" && (c.PKCS12Password != "" || c.PKCS12PasswordFile != "") {
+		return errors.New("pkcs12_password and pkcs12_password_file require pkcs12_file")
+	}
+	for _, pin := range c.PinnedSPKISHA256 {
+		if hash, err := base64.StdEncoding.DecodeString(pin); err != nil || len(hash) != sha256.Size {
+			return fmt.Errorf("pinned_spki_sha256 %q is not a base64 encoded SHA-256 hash", pin)
+		}
+	}
+	return nil
+}
+
+// Load reads the configuration file at path, applies defaults and validates it.
+func Load(path string) (*Config, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config file: %w", err)
+	}
+
+	cfg, err := Parse(content)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load config file %q: %w", path, err)
+	}
+	return cfg, nil
+}
+
+// Parse parses the YAML encoded configuration, applies defaults and validates it.
+func Parse(content []byte) (*Config, error) {
+	cfg := &Config{}
+	if err := yaml.UnmarshalStrict(content, cfg); err != nil {
+		return nil, fmt.Errorf("failed to parse config: %w", err)
+	}
+
+	for name, module := range cfg.Modules {
+		module.applyDefaults()
+		if err := module.Validate(); err != nil {
+			return nil, fmt.Errorf("module %q: %w", name, err)
+		}
+		cfg.Modules[name] = module
+	}
+
+	names := make(map[string]bool, len(cfg.Targets))
+	for i := range cfg.Targets {
+		target := &cfg.Targets[i]
+		target.applyDefaults()
+		if err := target.Validate(); err != nil {
+			return nil, fmt.Errorf("target %d (%q): %w", i, target.Name, err)
+		}
+		if names[target.Name] {
+			return nil, fmt.Errorf("target %d: duplicate target name %q", i, target.Name)
+		}
+		names[target.Name] = true
+	}
+
+	for i := range cfg.FileSDConfigs {
+		sd := &cfg.FileSDConfigs[i]
+		sd.applyDefaults()
+		if err := sd.Validate(); err != nil {
+			return nil, fmt.Errorf("file_sd_configs %d: %w", i, err)
+		}
+	}
+
+	for i := range cfg.DNSSDConfigs {
+		sd := &cfg.DNSSDConfigs[i]
+		sd.applyDefaults()
+		if err := sd.Validate(); err != nil {
+			return nil, fmt.Errorf("dns_sd_configs %d: %w", i, err)
+		}
+	}
+
+	for i := range cfg.KubernetesSDConfigs {
+		sd := &cfg.KubernetesSDConfigs[i]
+		sd.TargetTemplate.applyDefaults()
+		if err := sd.Validate(); err != nil {
+			return nil, fmt.Errorf("kubernetes_sd_configs %d: %w", i, err)
+		}
+	}
+
+	return cfg, nil
+}
+
+func (t *Target) applyDefaults() {
+	if t.Name == "" {
+		t.Name = t.URI
+	}
+	t.Module.applyDefaults()
+}
+
+// Validate checks the target settings.
+func (t *Target) Validate() error {
+	if err := ValidateURI(t.URI); err != nil {
+		return err
+	}
+	if err := ValidateLabels(t.Labels); err != nil {
+		return err
+	}
+	if isUnixSocketURI(t.URI) {
+		if t.ProxyURL != "" {
+			return errors.New("proxy_url cannot be used with a unix domain socket")
+		}
+		// the header of a unix domain socket connection has the socket addresses, which
+		// cannot be replaced by an IP address or sent in a version 1 header
+		if t.ProxyProtocol.Enabled && t.ProxyProtocol.SourceAddress != "" {
+			return errors.New("proxy_protocol: source_address cannot be used with a unix domain socket")
+		}
+		if t.ProxyProtocol.Enabled && t.ProxyProtocol.Version == 1 {
+			return errors.New("proxy_protocol: version 1 cannot be used with a unix domain socket")
+		}
+	}
+	return t.Module.Validate()
+}
+
+// isUnixSocketURI returns true if uri is the address of a unix domain socket.
+func isUnixSocketURI(uri string) bool {
+	return strings.HasPrefix(uri, "unix:") || strings.HasPrefix(uri, "http+unix:") || strings.HasPrefix(uri, "https+unix:")
+}
+
+// ValidateLabels checks that labels can be used as const labels. Label names starting
+// with __ are reserved for internal use.
+func ValidateLabels(labels map[string]string) error {
+	for name := range labels {
+		if !model.LegacyValidation.IsValidLabelName(name) || strings.HasPrefix(name, model.ReservedLabelPrefix) {
+			return fmt.Errorf("invalid label name %q", name)
+		}
+	}
+	return nil
+}
+
+// ValidateURI checks that uri is an HTTP or HTTPS URL or a unix domain socket address,
+// either an http+unix or https+unix URL or the legacy unix:<socket path>:<request path>.
+func ValidateURI(uri string) error {
+	if uri == "" {
+		return errors.New("uri is required")
+	}
+	if strings.HasPrefix(uri, "unix:") {
+		return nil
+	}
+
+	u, err := url.Parse(uri)
+	if err != nil {
+		return fmt.Errorf("invalid uri: %w", err)
+	}
+	switch u.Scheme {
+	case "http", "https":
+	case "http+unix", "https+unix":
+		if socketPath, _, _ := strings.Cut(u.EscapedPath(), ":"); socketPath == "" || socketPath == "/" {
+			return fmt.Errorf("invalid uri %q: socket path is missing", uri)
+		}
+		return nil
+	default:
+		return fmt.Errorf("invalid uri %q: scheme must be http, https, http+unix, https+unix or unix", uri)
+	}
+	if u.Host == "" {
+		return fmt.Errorf("invalid uri %q: host is missing", uri)
+	}
+	return nil
+}
+
+func (c *FileSDConfig) applyDefaults() {
+	if c.RefreshInterval == 0 {
+		c.RefreshInterval = DefaultRefreshInterval
+	}
+	c.TargetTemplate.applyDefaults()
+}
+
+// Validate checks the file service discovery settings.
+func (c *FileSDConfig) Validate() error {
+	if len(c.Files) == 0 {
+		return errors.New("files are required")
+	}
+	for _, file := range c.Files {
+		if _, err := filepath.Match(file, ""); err != nil {
+			return fmt.Errorf("invalid file pattern %q: %w", file, err)
+		}
+	}
+	if c.RefreshInterval < 0 {
+		return fmt.Errorf("negative refresh_interval %v is not valid", c.RefreshInterval)
+	}
+	return c.TargetTemplate.Validate()
+}
+
+func (c *DNSSDConfig) applyDefaults() {
+	if c.Type == "" {
+		c.Type = DNSRecordTypeSRV
+	}
+	if c.RefreshInterval == 0 {
+		c.RefreshInterval = DefaultRefreshInterval
+	}
+	c.TargetTemplate.applyDefaults()
+}
+
+// Validate checks the DNS service discovery settings.
+func (c *DNSSDConfig) Validate() error {
+	if len(c.Names) == 0 {
+		return errors.New("names are required")
+	}
+	switch c.Type {
+	case DNSRecordTypeSRV:
+	case DNSRecordTypeA, DNSRecordTypeAAAA:
+		if c.Port == 0 {
+			return fmt.Errorf("port is required for %s records", c.Type)
+		}
+	default:
+		return fmt.Errorf("invalid type %q, must be %q, %q or %q", c.Type, DNSRecordTypeSRV, DNSRecordTypeA, DNSRecordTypeAAAA)
+	}
+	if c.RefreshInterval < 0 {
+		return fmt.Errorf("negative refresh_interval %v is not valid", c.RefreshInterval)
+	}
+	return c.TargetTemplate.Validate()
+}
+
+// Validate checks the Kubernetes service discovery settings.
+func (c *KubernetesSDConfig) Validate() error {
+	if c.APIServer != "" {
+		u, err := url.Parse(c.APIServer)
+		if err != nil {
+			return fmt.Errorf("invalid api_server: %w", err)
+		}
+		if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("invalid api_server %q: must be an http or https URL", c.APIServer)
+		}
+	}
+	if err := c.APIServerTLSConfig.Validate(); err != nil {
+		return fmt.Errorf("api_server_tls_config: %w", err)
+	}
+	for _, namespace := range c.Namespaces {
+		if namespace == "" {
+			return errors.New("empty namespace")
+		}
+	}
+	return c.TargetTemplate.Validate()
+}
+
+func (t *TargetTemplate) applyDefaults() {
+	if t.Scheme == "" {
+		t.Scheme = "http"
+	}
+	t.Module.applyDefaults()
+}
+
+// Validate checks the settings of the discovered targets.
+func (t *TargetTemplate) Validate() error {
+	if t.Scheme != "http" && t.Scheme != "https" {
+		return fmt.Errorf("invalid scheme %q, must be http or https", t.Scheme)
+	}
+	if t.Path != "" && !strings.HasPrefix(t.Path, "/") {
+		return fmt.Errorf("invalid path %q, must start with /", t.Path)
+	}
+	if err := ValidateLabels(t.Labels); err != nil {
+		return err
+	}
+	return t.Module.Validate()
+}
+
+func (m *Module) applyDefaults() {
+	if m.Mode == "" {
+		m.Mode = ModeOSS
+	}
+	if m.Timeout == 0 {
+		m.Timeout = DefaultTimeout
+	}
+	if m.ProxyProtocol.Enabled && m.ProxyProtocol.Version == 0 {
+		m.ProxyProtocol.Version = 2
+	}
+	if m.Retry.Attempts > 1 {
+		if m.Retry.InitialBackoff == 0 {
+			m.Retry.InitialBackoff = DefaultRetryInitialBackoff
+		}
+		if m.Retry.MaxBackoff == 0 {
+			m.Retry.MaxBackoff = max(DefaultRetryMaxBackoff, m.Retry.InitialBackoff)
+		}
+	}
+	if len(m.KeyvalValues.HTTPZones)+len(m.KeyvalValues.StreamZones) > 0 && m.KeyvalValues.MaxKeysPerZone == 0 {
+		m.KeyvalValues.MaxKeysPerZone = DefaultKeyvalMaxKeysPerZone
+	}
+}
+
+// Validate checks the module settings.
+func (m *Module) Validate() error {
+	if m.Mode != ModeOSS && m.Mode != ModePlus {
+		return fmt.Errorf("invalid mode %q, must be %q or %q", m.Mode, ModeOSS, ModePlus)
+	}
+	if m.Timeout < 0 {
+		return fmt.Errorf("negative timeout %v is not valid", m.Timeout)
+	}
+	if m.PollInterval < 0 {
+		return fmt.Errorf("negative poll_interval %v is not valid", m.PollInterval)
+	}
+	if m.StaleGracePeriod < 0 {
+		return fmt.Errorf("negative stale_grace_period %v is not valid", m.StaleGracePeriod)
+	}
+	if err := m.ProxyProtocol.Validate(); err != nil {
+		return err
+	}
+	if err := m.Retry.Validate(); err != nil {
+		return err
+	}
+	if err := m.KeyvalValues.Validate(); err != nil {
+		return err
+	}
+	if err := validateProxyURL(m.ProxyURL); err != nil {
+		return err
+	}
+	if err := m.validateAuth(); err != nil {
+		return err
+	}
+	for _, pattern := range m.ProbeAllowedHosts {
+		if _, err := regexp.Compile(pattern); err != nil {
+			return fmt.Errorf("invalid probe_allowed_hosts pattern %q: %w", pattern, err)
+		}
+	}
+	if err := m.TLSConfig.Validate(); err != nil {
+		return fmt.Errorf("tls_config: %w", err)
+	}
+	return nil
+}
+
+// validateProxyURL checks that proxyURL is empty or a URL of a supported proxy.
+func validateProxyURL(proxyURL string) error {
+	if proxyURL == "" {
+		return nil
+	}
+	u, err := url.Parse(proxyURL)
+	if err != nil || u.Host == "" || !slices.Contains([]string{"http", "https", "socks5", "socks5h"}, u.Scheme) {
+		return fmt.Errorf("proxy_url %q must be an http, https, socks5 or socks5h URL", proxyURL)
+	}
+	return nil
+}
+
+// HasCredentials reports whether the module sends credentials to NGINX or NGINX Plus:
+// basic auth, a bearer token, OAuth2 access tokens, a client certificate or an
+// Authorization header. Other headers, such as a Host override, are not credentials.
+func (m *Module) HasCredentials() bool {
+	for name := range m.Headers {
+		if strings.EqualFold(name, "Authorization") {
+			return true
+		}
+	}
+	return m.BasicAuth != nil || m.BearerTokenFile != "" || m.OAuth2 != nil ||
+		m.TLSConfig.CertFile != "" || m.TLSConfig.PKCS12File != ""
+}
+
+// AllowsProbeHost reports whether one of ProbeAllowedHosts matches the whole host.
+func (m *Module) AllowsProbeHost(host string) bool {
+	for _, pattern := range m.ProbeAllowedHosts {
+		re, err := regexp.Compile("^(?:" + pattern + ")$")
+		if err == nil && re.MatchString(host) {
+			return true
+		}
+	}
+	return false
+}
+
+func (m *Module) validateAuth() error {
+	configured := 0
+	for _, set := range []bool{m.BasicAuth != nil, m.BearerTokenFile != "", m.OAuth2 != nil} {
+		if set {
+			configured++
+		}
+	}
+	if configured > 1 {
+		return errors.New("at most one of basic_auth, bearer_token_file and oauth2 must be set")
+	}
+
+	if m.BasicAuth != nil {
+		if m.BasicAuth.Username == "" {
+			return errors.New("basic_auth: username is required")
+		}
+		if m.BasicAuth.Password != "" && m.BasicAuth.PasswordFile != "" {
+			return errors.New("basic_auth: at most one of password and password_file must be set")
+		}
+	}
+
+	if m.OAuth2 != nil {
+		if m.OAuth2.ClientID == "" {
+			return errors.New("oauth2: client_id is required")
+		}
+		if (m.OAuth2.ClientSecret == "") == (m.OAuth2.ClientSecretFile == "") {
+			return errors.New("oauth2: exactly one of client_secret and client_secret_file must be set")
+		}
+		u, err := url.Parse(m.OAuth2.TokenURL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("oauth2: token_url %q must be an http or https URL", m.OAuth2.TokenURL)
+		}
+		if err := validateProxyURL(m.OAuth2.ProxyURL); err != nil {
+			return fmt.Errorf("oauth2: %w", err)
+		}
+		if err := m.OAuth2.TLSConfig.Validate(); err != nil {
+			return fmt.Errorf("oauth2: tls_config: %w", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,697 @@
+package config
+
+import (
+	"crypto/tls"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParse(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		want    *Config
+		name    string
+		input   string
+		wantErr bool
+	}{
+		{
+			name: "module defaults",
+			input: `
+modules:
+  default: {}
+`,
+			want: &Config{
+				Modules: map[string]Module{
+					"default": {Mode: ModeOSS, Timeout: DefaultTimeout},
+				},
+			},
+		},
+		{
+			name: "plus module with TLS and headers",
+			input: `
+modules:
+  plus_mtls:
+    mode: plus
+    timeout: 10s
+    headers:
+      X-Scrape: exporter
+    tls_config:
+      ca_file: /etc/ssl/ca.pem
+      cert_file: /etc/ssl/client.pem
+      key_file: /etc/ssl/client.key
+`,
+			want: &Config{
+				Modules: map[string]Module{
+					"plus_mtls": {
+						Mode:    ModePlus,
+						Timeout: 10 * time.Second,
+						Headers: map[string]string{"X-Scrape": "exporter"},
+						TLSConfig: TLSConfig{
+							CAFile:   "/etc/ssl/ca.pem",
+							CertFile: "/etc/ssl/client.pem",
+							KeyFile:  "/etc/ssl/client.key",
+						},
+					},
+				},
+			},
+		},
+		{
+			name: "invalid mode",
+			input: `
+modules:
+  broken:
+    mode: stub_status
+`,
+			wantErr: true,
+		},
+		{
+			name: "client certificate without key",
+			input: `
+modules:
+  broken:
+    tls_config:
+      cert_file: /etc/ssl/client.pem
+`,
+			wantErr: true,
+		},
+		{
+			name: "advanced TLS options",
+			input: `
+modules:
+  pinned:
+    tls_config:
+      server_name: nginx.example.com
+      min_version: TLS12
+      cipher_suites: [TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256, TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256]
+      pkcs12_file: /etc/ssl/client.p12
+      pkcs12_password_file: /etc/ssl/client.p12.pass
+      pinned_spki_sha256: [47DEQpj8HBSa+/TImW+5JCeuQeRkm5NMpJWZG3hSuFU=]
+`,
+			want: &Config{
+				Modules: map[string]Module{
+					"pinned": {
+						Mode:    ModeOSS,
+						Timeout: DefaultTimeout,
+						TLSConfig: TLSConfig{
+							ServerName:         "nginx.example.com",
+							MinVersion:         TLSVersion(tls.VersionTLS12),
+							CipherSuites:       []CipherSuite{CipherSuite(tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256), CipherSuite(tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256)},
+							PKCS12File:         "/etc/ssl/client.p12",
+							PKCS12PasswordFile: "/etc/ssl/client.p12.pass",
+							PinnedSPKISHA256:   []string{"47DEQpj8HBSa+/TImW+5JCeuQeRkm5NMpJWZG3hSuFU="},
+						},
+					},
+				},
+			},
+		},
+		{
+			name: "unknown TLS version",
+			input: `
+modules:
+  broken:
+    tls_config:
+      min_version: SSL3
+`,
+			wantErr: true,
+		},
+		{
+			name: "unknown cipher suite",
+			input: `
+modules:
+  broken:
+    tls_config:
+      cipher_suites: [TLS_NULL_WITH_NULL_NULL]
+`,
+			wantErr: true,
+		},
+		{
+			name: "pkcs12 bundle with client certificate",
+			input: `
+modules:
+  broken:
+    tls_config:
+      pkcs12_file: /etc/ssl/client.p12
+      cert_file: /etc/ssl/client.pem
+      key_file: /etc/ssl/client.key
+`,
+			wantErr: true,
+		},
+		{
+			name: "invalid public key pin",
+			input: `
+modules:
+  broken:
+    tls_config:
+      pinned_spki_sha256: [not-a-hash]
+`,
+			wantErr: true,
+		},
+		{
+			name: "basic auth without username",
+			input: `
+modules:
+  broken:
+    basic_auth:
+      password: secret
+`,
+			wantErr: true,
+		},
+		{
+			name: "basic auth with password and password file",
+			input: `
+modules:
+  broken:
+    basic_auth:
+      username: prometheus
+      password: secret
+      password_file: /etc/nginx-exporter/password
+`,
+			wantErr: true,
+		},
+		{
+			name: "basic auth with bearer token file",
+			input: `
+modules:
+  broken:
+    basic_auth:
+      username: prometheus
+      password_file: /etc/nginx-exporter/password
+    bearer_token_file: /etc/nginx-exporter/token
+`,
+			wantErr: true,
+		},
+		{
+			name: "oauth2 module",
+			input: `
+modules:
+  gateway:
+    mode: plus
+    oauth2:
+      client_id: exporter
+      client_secret_file: /etc/nginx-exporter/client-secret
+      token_url: https://auth.example.com/oauth2/token
+      scopes: [nginx.read]
+      proxy_url: http://proxy.example.com:3128
+      tls_config:
+        ca_file: /etc/nginx-exporter/auth-ca.pem
+`,
+			want: &Config{
+				Modules: map[string]Module{
+					"gateway": {
+						Mode:    ModePlus,
+						Timeout: DefaultTimeout,
+						OAuth2: &OAuth2{
+							ClientID:         "exporter",
+							ClientSecretFile: "/etc/nginx-exporter/client-secret",
+							TokenURL:         "https://auth.example.com/oauth2/token",
+							Scopes:           []string{"nginx.read"},
+							ProxyURL:         "http://proxy.example.com:3128",
+							TLSConfig:        TLSConfig{CAFile: "/etc/nginx-exporter/auth-ca.pem"},
+						},
+					},
+				},
+			},
+		},
+		{
+			name: "oauth2 without token url",
+			input: `
+modules:
+  broken:
+    oauth2:
+      client_id: exporter
+      client_secret: secret
+`,
+			wantErr: true,
+		},
+		{
+			name: "oauth2 with invalid proxy url",
+			input: `
+modules:
+  broken:
+    oauth2:
+      client_id: exporter
+      client_secret: secret
+      token_url: https://auth.example.com/oauth2/token
+      proxy_url: ftp://proxy.example.com
+`,
+			wantErr: true,
+		},
+		{
+			name: "negative stale grace period",
+			input: `
+modules:
+  broken:
+    stale_grace_period: -1m
+`,
+			wantErr: true,
+		},
+		{
+			name: "retry defaults",
+			input: `
+modules:
+  retrying:
+    retry:
+      attempts: 3
+`,
+			want: &Config{
+				Modules: map[string]Module{
+					"retrying": {
+						Mode:    ModeOSS,
+						Timeout: DefaultTimeout,
+						Retry: Retry{
+							Attempts:       3,
+							InitialBackoff: DefaultRetryInitialBackoff,
+							MaxBackoff:     DefaultRetryMaxBackoff,
+						},
+					},
+				},
+			},
+		},
+		{
+			name: "retry with max backoff shorter than initial backoff",
+			input: `
+modules:
+  broken:
+    retry:
+      attempts: 3
+      initial_backoff: 2s
+      max_backoff: 1s
+`,
+			wantErr: true,
+		},
+		{
+			name: "keyval values defaults",
+			input: `
+modules:
+  keyvals:
+    mode: plus
+    keyval_values:
+      http_zones:
+        ratelimit: ['10\..*', 'total']
+        flags: []
+      stream_zones:
+        ratelimit: ['tcp_.*']
+`,
+			want: &Config{
+				Modules: map[string]Module{
+					"keyvals": {
+						Mode:    ModePlus,
+						Timeout: DefaultTimeout,
+						KeyvalValues: KeyvalValues{
+							HTTPZones:      map[string][]string{"ratelimit": {`10\..*`, "total"}, "flags": {}},
+							StreamZones:    map[string][]string{"ratelimit": {"tcp_.*"}},
+							MaxKeysPerZone: DefaultKeyvalMaxKeysPerZone,
+						},
+					},
+				},
+			},
+		},
+		{
+			name: "keyval values with invalid key pattern",
+			input: `
+modules:
+  broken:
+    mode: plus
+    keyval_values:
+      stream_zones:
+        ratelimit: ['10\.(']
+`,
+			wantErr: true,
+		},
+		{
+			name: "module with invalid probe allowed hosts pattern",
+			input: `
+modules:
+  broken:
+    bearer_token_file: /etc/nginx-exporter/token
+    probe_allowed_hosts: ['lb(']
+`,
+			wantErr: true,
+		},
+		{
+			name: "targets with defaults",
+			input: `
+targets:
+  - uri: http://10.0.0.1:8080/stub_status
+  - name: lb1
+    uri: https://lb1:8443/api
+    mode: plus
+    timeout: 2s
+    proxy_protocol: true
+    labels:
+      dc: eu-west
+    tls_config:
+      ca_file: /etc/ssl/ca.pem
+  - uri: unix:/var/run/nginx.sock:/stub_status
+`,
+			want: &Config{
+				Targets: []Target{
+					{
+						Name:   "http://10.0.0.1:8080/stub_status",
+						URI:    "http://10.0.0.1:8080/stub_status",
+						Module: Module{Mode: ModeOSS, Timeout: DefaultTimeout},
+					},
+					{
+						Name:   "lb1",
+						URI:    "https://lb1:8443/api",
+						Labels: map[string]string{"dc": "eu-west"},
+						Module: Module{
+							Mode:          ModePlus,
+							Timeout:       2 * time.Second,
+							ProxyProtocol: ProxyProtocol{Enabled: true, Version: 2},
+							TLSConfig:     TLSConfig{CAFile: "/etc/ssl/ca.pem"},
+						},
+					},
+					{
+						Name:   "unix:/var/run/nginx.sock:/stub_status",
+						URI:    "unix:/var/run/nginx.sock:/stub_status",
+						Module: Module{Mode: ModeOSS, Timeout: DefaultTimeout},
+					},
+				},
+			},
+		},
+		{
+			name: "target without uri",
+			input: `
+targets:
+  - name: lb1
+`,
+			wantErr: true,
+		},
+		{
+			name: "target with unsupported scheme",
+			input: `
+targets:
+  - uri: ftp://lb1/api
+`,
+			wantErr: true,
+		},
+		{
+			name: "target with invalid label name",
+			input: `
+targets:
+  - uri: http://lb1/api
+    labels:
+      data-center: eu-west
+`,
+			wantErr: true,
+		},
+		{
+			name: "unix domain socket url targets",
+			input: `
+targets:
+  - uri: http+unix:///run/nginx.sock:/stub_status
+  - uri: https+unix://nginx.example.com/@nginx:/api
+    mode: plus
+`,
+			want: &Config{
+				Targets: []Target{
+					{
+						Name:   "http+unix:///run/nginx.sock:/stub_status",
+						URI:    "http+unix:///run/nginx.sock:/stub_status",
+						Module: Module{Mode: ModeOSS, Timeout: DefaultTimeout},
+					},
+					{
+						Name:   "https+unix://nginx.example.com/@nginx:/api",
+						URI:    "https+unix://nginx.example.com/@nginx:/api",
+						Module: Module{Mode: ModePlus, Timeout: DefaultTimeout},
+					},
+				},
+			},
+		},
+		{
+			name: "unix domain socket url target without socket path",
+			input: `
+targets:
+  - uri: http+unix://nginx.example.com
+`,
+			wantErr: true,
+		},
+		{
+			name: "target with unsupported proxy url",
+			input: `
+targets:
+  - uri: http://lb1/api
+    proxy_url: ftp://proxy.example.com
+`,
+			wantErr: true,
+		},
+		{
+			name: "unix domain socket target with proxy url",
+			input: `
+targets:
+  - uri: unix:/var/run/nginx.sock:/stub_status
+    proxy_url: http://proxy.example.com:3128
+`,
+			wantErr: true,
+		},
+		{
+			name: "unix domain socket url target with proxy url",
+			input: `
+targets:
+  - uri: http+unix:///var/run/nginx.sock:/stub_status
+    proxy_url: http://proxy.example.com:3128
+`,
+			wantErr: true,
+		},
+		{
+			name: "target with proxy protocol settings",
+			input: `
+targets:
+  - uri: https://lb1:8443/api
+    proxy_protocol:
+      version: 2
+      source_address: 192.0.2.10
+      tlvs:
+        authority: lb1.example.com
+        alpn: http/1.1
+`,
+			want: &Config{
+				Targets: []Target{
+					{
+						Name: "https://lb1:8443/api",
+						URI:  "https://lb1:8443/api",
+						Module: Module{
+							Mode:    ModeOSS,
+							Timeout: DefaultTimeout,
+							ProxyProtocol: ProxyProtocol{
+								Enabled:       true,
+								Version:       2,
+								SourceAddress: "192.0.2.10",
+								TLVs:          ProxyProtocolTLVs{Authority: "lb1.example.com", ALPN: "http/1.1"},
+							},
+						},
+					},
+				},
+			},
+		},
+		{
+			name: "target with proxy protocol v1 and tlvs",
+			input: `
+targets:
+  - uri: http://lb1/api
+    proxy_protocol:
+      version: 1
+      tlvs:
+        unique_id: abc
+`,
+			wantErr: true,
+		},
+		{
+			name: "target with invalid proxy protocol source address",
+			input: `
+targets:
+  - uri: http://lb1/api
+    proxy_protocol:
+      source_address: lb1.example.com
+`,
+			wantErr: true,
+		},
+		{
+			name: "unix domain socket target with proxy protocol source address",
+			input: `
+targets:
+  - uri: unix:/var/run/nginx.sock:/stub_status
+    proxy_protocol:
+      source_address: 192.0.2.10
+`,
+			wantErr: true,
+		},
+		{
+			name: "unix domain socket url target with proxy protocol v1",
+			input: `
+targets:
+  - uri: http+unix:///var/run/nginx.sock:/stub_status
+    proxy_protocol:
+      version: 1
+`,
+			wantErr: true,
+		},
+		{
+			name: "targets with duplicate names",
+			input: `
+targets:
+  - name: lb
+    uri: http://lb1/api
+  - name: lb
+    uri: http://lb2/api
+`,
+			wantErr: true,
+		},
+		{
+			name: "file service discovery with defaults",
+			input: `
+file_sd_configs:
+  - files: [/etc/nginx-exporter/targets/*.json]
+  - files: [/etc/nginx-exporter/plus.yml]
+    refresh_interval: 1m
+    scheme: https
+    path: /api
+    mode: plus
+    labels:
+      dc: eu-west
+`,
+			want: &Config{
+				FileSDConfigs: []FileSDConfig{
+					{
+						Files:           []string{"/etc/nginx-exporter/targets/*.json"},
+						RefreshInterval: DefaultRefreshInterval,
+						TargetTemplate: TargetTemplate{
+							Scheme: "http",
+							Module: Module{Mode: ModeOSS, Timeout: DefaultTimeout},
+						},
+					},
+					{
+						Files:           []string{"/etc/nginx-exporter/plus.yml"},
+						RefreshInterval: time.Minute,
+						TargetTemplate: TargetTemplate{
+							Labels: map[string]string{"dc": "eu-west"},
+							Scheme: "https",
+							Path:   "/api",
+							Module: Module{Mode: ModePlus, Timeout: DefaultTimeout},
+						},
+					},
+				},
+			},
+		},
+		{
+			name: "file service discovery without files",
+			input: `
+file_sd_configs:
+  - refresh_interval: 1m
+`,
+			wantErr: true,
+		},
+		{
+			name: "file service discovery with invalid pattern",
+			input: `
+file_sd_configs:
+  - files: ["/etc/nginx-exporter/[.json"]
+`,
+			wantErr: true,
+		},
+		{
+			name: "kubernetes service discovery",
+			input: `
+kubernetes_sd_configs:
+  - api_server: https://k8s.example.com:6443
+    api_server_bearer_token_file: /etc/nginx-exporter/token
+    namespaces: [ingress]
+    label_selector: app=nginx
+    pod_labels: [app]
+    api_server_tls_config:
+      ca_file: /etc/nginx-exporter/k8s-ca.pem
+`,
+			want: &Config{
+				KubernetesSDConfigs: []KubernetesSDConfig{
+					{
+						APIServer:                "https://k8s.example.com:6443",
+						APIServerBearerTokenFile: "/etc/nginx-exporter/token",
+						Namespaces:               []string{"ingress"},
+						LabelSelector:            "app=nginx",
+						PodLabels:                []string{"app"},
+						APIServerTLSConfig:       TLSConfig{CAFile: "/etc/nginx-exporter/k8s-ca.pem"},
+						TargetTemplate: TargetTemplate{
+							Scheme: "http",
+							Module: Module{Mode: ModeOSS, Timeout: DefaultTimeout},
+						},
+					},
+				},
+			},
+		},
+		{
+			name: "kubernetes service discovery with invalid api server",
+			input: `
+kubernetes_sd_configs:
+  - api_server: k8s.example.com
+`,
+			wantErr: true,
+		},
+		{
+			name: "dns service discovery with defaults",
+			input: `
+dns_sd_configs:
+  - names: [_nginx._tcp.service.consul]
+  - names: [nginx.example.com]
+    type: A
+    port: 8080
+`,
+			want: &Config{
+				DNSSDConfigs: []DNSSDConfig{
+					{
+						Names:           []string{"_nginx._tcp.service.consul"},
+						Type:            DNSRecordTypeSRV,
+						RefreshInterval: DefaultRefreshInterval,
+						TargetTemplate: TargetTemplate{
+							Scheme: "http",
+							Module: Module{Mode: ModeOSS, Timeout: DefaultTimeout},
+						},
+					},
+					{
+						Names:           []string{"nginx.example.com"},
+						Type:            DNSRecordTypeA,
+						Port:            8080,
+						RefreshInterval: DefaultRefreshInterval,
+						TargetTemplate: TargetTemplate{
+							Scheme: "http",
+							Module: Module{Mode: ModeOSS, Timeout: DefaultTimeout},
+						},
+					},
+				},
+			},
+		},
+		{
+			name: "dns service discovery of A records without port",
+			input: `
+dns_sd_configs:
+  - names: [nginx.example.com]
+    type: A
+`,
+			wantErr: true,
+		},
+		{
+			name: "unknown field",
+			input: `
+modules:
+  broken:
+    timeuot: 5s
+`,
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			got, err := Parse([]byte(tt.input))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Parse() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Parse() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
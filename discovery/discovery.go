@@ -0,0 +1,59 @@
+// Package discovery finds the NGINX and NGINX Plus instances scraped by the exporter.
+package discovery
+
+import (
+	"context"
+	"reflect"
+	"time"
+)
+
+const (
+	// ModeLabel overrides the mode of the module used to scrape a target.
+	ModeLabel = "__mode__"
+	// SchemeLabel overrides the scheme of the scrape URI of a target.
+	SchemeLabel = "__scheme__"
+	// MetricsPathLabel overrides the request path of the scrape URI of a target.
+	MetricsPathLabel = "__metrics_path__"
+)
+
+// Target is a discovered NGINX or NGINX Plus instance.
+type Target struct {
+	// Labels are the labels of the target. Labels starting with __ are not added to
+	// the metrics of the target, but may change the way it is scraped.
+	Labels map[string]string
+	// Address is the host and port of the instance.
+	Address string
+}
+
+// Discoverer finds targets.
+type Discoverer interface {
+	// Run sends the complete set of targets to ch every time it changes, until ctx is canceled.
+	Run(ctx context.Context, ch chan<- []Target)
+}
+
+// runRefreshLoop calls refresh every interval and sends the targets to ch if they changed,
+// until ctx is canceled.
+func runRefreshLoop(ctx context.Context, ch chan<- []Target, interval time.Duration, refresh func(context.Context) []Target) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	var last []Target
+	sent := false
+	for {
+		targets := refresh(ctx)
+		if !sent || !reflect.DeepEqual(targets, last) {
+			select {
+			case ch <- targets:
+				last, sent = targets, true
+			case <-ctx.Done():
+				return
+			}
+		}
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,119 @@
+package discovery
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nginx/nginx-prometheus-exporter/config"
+)
+
+// Resolver looks up DNS records. It is implemented by net.Resolver.
+type Resolver interface {
+	LookupSRV(ctx context.Context, service, proto, name string) (string, []*net.SRV, error)
+	LookupIP(ctx context.Context, network, host string) ([]net.IP, error)
+}
+
+// DNSDiscoverer finds targets by resolving DNS names every refresh interval. SRV records
+// give the host and port of the targets, A and AAAA records give the IP addresses of the
+// targets, which are combined with a fixed port. The host name and port of a target are
+// added as the hostname and port labels. If a name cannot be resolved because of a
+// temporary error, its previous targets are kept.
+type DNSDiscoverer struct {
+	logger          *slog.Logger
+	resolver        Resolver
+	resolved        map[string][]Target
+	recordType      string
+	names           []string
+	refreshInterval time.Duration
+	port            uint16
+}
+
+// NewDNSDiscoverer creates a DNSDiscoverer for the names in cfg.
+func NewDNSDiscoverer(logger *slog.Logger, resolver Resolver, cfg config.DNSSDConfig) *DNSDiscoverer {
+	return &DNSDiscoverer{
+		logger:          logger,
+		resolver:        resolver,
+		resolved:        make(map[string][]Target),
+		recordType:      cfg.Type,
+		names:           cfg.Names,
+		refreshInterval: cfg.RefreshInterval,
+		port:            cfg.Port,
+	}
+}
+
+// Run implements the Discoverer interface.
+func (d *DNSDiscoverer) Run(ctx context.Context, ch chan<- []Target) {
+	runRefreshLoop(ctx, ch, d.refreshInterval, d.refresh)
+}
+
+func (d *DNSDiscoverer) refresh(ctx context.Context) []Target {
+	targets := []Target{}
+	for _, name := range d.names {
+		found, err := d.lookup(ctx, name)
+		var dnsErr *net.DNSError
+		if errors.As(err, &dnsErr) && dnsErr.IsNotFound {
+			// the name no longer exists, so neither do its targets
+			found, err = nil, nil
+		}
+		if err != nil {
+			d.logger.Error("resolving name failed", "name", name, "type", d.recordType, "error", err.Error())
+			found = d.resolved[name]
+		}
+		d.resolved[name] = found
+		targets = append(targets, found...)
+	}
+	return targets
+}
+
+func (d *DNSDiscoverer) lookup(ctx context.Context, name string) ([]Target, error) {
+	var targets []Target
+
+	switch d.recordType {
+	case config.DNSRecordTypeSRV:
+		_, records, err := d.resolver.LookupSRV(ctx, "", "", name)
+		if err != nil {
+			return nil, fmt.Errorf("failed to look up SRV records: %w", err)
+		}
+		for _, record := range records {
+			targets = append(targets, dnsTarget(strings.TrimSuffix(record.Target, "."), record.Target, record.Port))
+		}
+	default:
+		network := "ip4"
+		if d.recordType == config.DNSRecordTypeAAAA {
+			network = "ip6"
+		}
+		ips, err := d.resolver.LookupIP(ctx, network, name)
+		if err != nil {
+			return nil, fmt.Errorf("failed to look up %s records: %w", d.recordType, err)
+		}
+		for _, ip := range ips {
+			targets = append(targets, dnsTarget(strings.TrimSuffix(name, "."), ip.String(), d.port))
+		}
+	}
+
+	slices.SortFunc(targets, func(a, b Target) int {
+		return strings.Compare(a.Address, b.Address)
+	})
+	return slices.CompactFunc(targets, func(a, b Target) bool {
+		return a.Address == b.Address
+	}), nil
+}
+
+func dnsTarget(hostname string, host string, port uint16) Target {
+	host = strings.TrimSuffix(host, ".")
+	p := strconv.Itoa(int(port))
+	return Target{
+		Address: net.JoinHostPort(host, p),
+		Labels: map[string]string{
+			"hostname": hostname,
+			"port":     p,
+		},
+	}
+}
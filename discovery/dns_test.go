@@ -0,0 +1,138 @@
+package discovery
+
+import (
+	"context"
+	"errors"
+	"net"
+	"reflect"
+	"testing"
+
+	"github.com/nginx/nginx-prometheus-exporter/config"
+	"github.com/prometheus/common/promslog"
+)
+
+type fakeResolver struct {
+	srv map[string][]*net.SRV
+	ips map[string][]net.IP
+	err error
+}
+
+func (r *fakeResolver) LookupSRV(_ context.Context, _, _, name string) (string, []*net.SRV, error) {
+	if r.err != nil {
+		return "", nil, r.err
+	}
+	records, ok := r.srv[name]
+	if !ok {
+		return "", nil, &net.DNSError{Err: "no such host", Name: name, IsNotFound: true}
+	}
+	return name, records, nil
+}
+
+func (r *fakeResolver) LookupIP(_ context.Context, network, host string) ([]net.IP, error) {
+	if r.err != nil {
+		return nil, r.err
+	}
+	ips, ok := r.ips[network+"/"+host]
+	if !ok {
+		return nil, &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+	}
+	return ips, nil
+}
+
+func TestDNSDiscovererRefresh(t *testing.T) {
+	t.Parallel()
+
+	dnsTarget := func(address, hostname, port string) Target {
+		return Target{Address: address, Labels: map[string]string{"hostname": hostname, "port": port}}
+	}
+
+	tests := []struct {
+		resolver *fakeResolver
+		name     string
+		want     []Target
+		cfg      config.DNSSDConfig
+	}{
+		{
+			name: "SRV records",
+			cfg:  config.DNSSDConfig{Names: []string{"_nginx._tcp.service.consul"}, Type: config.DNSRecordTypeSRV},
+			resolver: &fakeResolver{srv: map[string][]*net.SRV{
+				"_nginx._tcp.service.consul": {
+					{Target: "web-2.node.consul.", Port: 8080},
+					{Target: "web-1.node.consul.", Port: 8080},
+				},
+			}},
+			want: []Target{
+				dnsTarget("web-1.node.consul:8080", "web-1.node.consul", "8080"),
+				dnsTarget("web-2.node.consul:8080", "web-2.node.consul", "8080"),
+			},
+		},
+		{
+			name: "A records",
+			cfg:  config.DNSSDConfig{Names: []string{"nginx.example.com"}, Type: config.DNSRecordTypeA, Port: 8080},
+			resolver: &fakeResolver{ips: map[string][]net.IP{
+				"ip4/nginx.example.com": {net.ParseIP("10.0.0.2"), net.ParseIP("10.0.0.1"), net.ParseIP("10.0.0.1")},
+			}},
+			want: []Target{
+				dnsTarget("10.0.0.1:8080", "nginx.example.com", "8080"),
+				dnsTarget("10.0.0.2:8080", "nginx.example.com", "8080"),
+			},
+		},
+		{
+			name: "AAAA records",
+			cfg:  config.DNSSDConfig{Names: []string{"nginx.example.com"}, Type: config.DNSRecordTypeAAAA, Port: 8443},
+			resolver: &fakeResolver{ips: map[string][]net.IP{
+				"ip6/nginx.example.com": {net.ParseIP("fd00::1")},
+			}},
+			want: []Target{
+				dnsTarget("[fd00::1]:8443", "nginx.example.com", "8443"),
+			},
+		},
+		{
+			name:     "unknown name",
+			cfg:      config.DNSSDConfig{Names: []string{"nginx.example.com"}, Type: config.DNSRecordTypeA, Port: 8080},
+			resolver: &fakeResolver{},
+			want:     []Target{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			d := NewDNSDiscoverer(promslog.NewNopLogger(), tt.resolver, tt.cfg)
+			if got := d.refresh(t.Context()); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("refresh() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDNSDiscovererRefreshChanges(t *testing.T) {
+	t.Parallel()
+
+	resolver := &fakeResolver{ips: map[string][]net.IP{
+		"ip4/nginx.example.com": {net.ParseIP("10.0.0.1"), net.ParseIP("10.0.0.2")},
+	}}
+	d := NewDNSDiscoverer(promslog.NewNopLogger(), resolver, config.DNSSDConfig{
+		Names: []string{"nginx.example.com"},
+		Type:  config.DNSRecordTypeA,
+		Port:  8080,
+	})
+
+	if got := d.refresh(t.Context()); len(got) != 2 {
+		t.Fatalf("refresh() = %v, want 2 targets", got)
+	}
+
+	// a temporary error keeps the previous targets
+	resolver.err = errors.New("server misbehaving")
+	if got := d.refresh(t.Context()); len(got) != 2 {
+		t.Errorf("refresh() with a resolver error = %v, want the previous 2 targets", got)
+	}
+
+	// a disappeared record removes its target
+	resolver.err = nil
+	resolver.ips["ip4/nginx.example.com"] = []net.IP{net.ParseIP("10.0.0.2")}
+	if got := d.refresh(t.Context()); len(got) != 1 || got[0].Address != "10.0.0.2:8080" {
+		t.Errorf("refresh() = %v, want only 10.0.0.2:8080", got)
+	}
+}
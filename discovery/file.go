@@ -0,0 +1,109 @@
+package discovery
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+
+	yaml "go.yaml.in/yaml/v2"
+)
+
+// targetGroup is a group of targets with common labels in the Prometheus file_sd format.
+type targetGroup struct {
+	Labels  map[string]string `json:"labels"  yaml:"labels"`
+	Targets []string          `json:"targets" yaml:"targets"`
+}
+
+// FileDiscoverer finds targets in JSON or YAML files in the Prometheus file_sd format.
+// The files are read again every refresh interval, so targets can be added and removed
+// by changing the files. If a file cannot be read, its previous targets are kept.
+type FileDiscoverer struct {
+	logger          *slog.Logger
+	files           map[string][]Target
+	patterns        []string
+	refreshInterval time.Duration
+}
+
+// NewFileDiscoverer creates a FileDiscoverer for the files matching patterns.
+func NewFileDiscoverer(logger *slog.Logger, patterns []string, refreshInterval time.Duration) *FileDiscoverer {
+	return &FileDiscoverer{
+		logger:          logger,
+		files:           make(map[string][]Target),
+		patterns:        patterns,
+		refreshInterval: refreshInterval,
+	}
+}
+
+// Run implements the Discoverer interface.
+func (d *FileDiscoverer) Run(ctx context.Context, ch chan<- []Target) {
+	runRefreshLoop(ctx, ch, d.refreshInterval, d.refresh)
+}
+
+func (d *FileDiscoverer) refresh(context.Context) []Target {
+	var paths []string
+	for _, pattern := range d.patterns {
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			d.logger.Error("matching file pattern failed", "pattern", pattern, "error", err.Error())
+			continue
+		}
+		paths = append(paths, matches...)
+	}
+	slices.Sort(paths)
+	paths = slices.Compact(paths)
+
+	files := make(map[string][]Target, len(paths))
+	targets := []Target{}
+	for _, path := range paths {
+		found, err := readTargetsFile(path)
+		if err != nil {
+			d.logger.Error("reading targets file failed", "file", path, "error", err.Error())
+			found = d.files[path]
+		}
+		files[path] = found
+		targets = append(targets, found...)
+	}
+	d.files = files
+
+	return targets
+}
+
+func readTargetsFile(path string) ([]Target, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read file: %w", err)
+	}
+
+	var groups []targetGroup
+	switch ext := filepath.Ext(path); ext {
+	case ".json":
+		err = json.Unmarshal(content, &groups)
+	case ".yml", ".yaml":
+		err = yaml.UnmarshalStrict(content, &groups)
+	default:
+		return nil, fmt.Errorf("unsupported file extension %q", ext)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse file: %w", err)
+	}
+
+	var targets []Target
+	for i, group := range groups {
+		for _, address := range group.Targets {
+			if address == "" {
+				return nil, fmt.Errorf("group %d: empty target address", i)
+			}
+			targets = append(targets, Target{
+				Address: address,
+				Labels:  maps.Clone(group.Labels),
+			})
+		}
+	}
+	return targets, nil
+}
@@ -0,0 +1,92 @@
+package discovery
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/prometheus/common/promslog"
+)
+
+func TestFileDiscovererRefresh(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	writeFile(t, filepath.Join(dir, "a.json"), `[
+  {"targets": ["10.0.0.1:8080", "10.0.0.2:8080"], "labels": {"env": "prod"}}
+]`)
+	writeFile(t, filepath.Join(dir, "b.yml"), `
+- targets: [lb1:8443]
+  labels:
+    __mode__: plus
+`)
+	writeFile(t, filepath.Join(dir, "c.txt"), "ignored")
+
+	d := NewFileDiscoverer(promslog.NewNopLogger(), []string{filepath.Join(dir, "*.json"), filepath.Join(dir, "*.yml")}, time.Minute)
+
+	want := []Target{
+		{Address: "10.0.0.1:8080", Labels: map[string]string{"env": "prod"}},
+		{Address: "10.0.0.2:8080", Labels: map[string]string{"env": "prod"}},
+		{Address: "lb1:8443", Labels: map[string]string{"__mode__": "plus"}},
+	}
+	if got := d.refresh(t.Context()); !reflect.DeepEqual(got, want) {
+		t.Errorf("refresh() = %v, want %v", got, want)
+	}
+
+	// a broken file keeps its previous targets
+	writeFile(t, filepath.Join(dir, "a.json"), `[{"targets": [`)
+	if got := d.refresh(t.Context()); !reflect.DeepEqual(got, want) {
+		t.Errorf("refresh() with a broken file = %v, want %v", got, want)
+	}
+
+	// a removed file drops its targets
+	if err := os.Remove(filepath.Join(dir, "a.json")); err != nil {
+		t.Fatal(err)
+	}
+	want = want[2:]
+	if got := d.refresh(t.Context()); !reflect.DeepEqual(got, want) {
+		t.Errorf("refresh() with a removed file = %v, want %v", got, want)
+	}
+}
+
+func TestFileDiscovererRun(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "targets.json")
+	writeFile(t, path, `[{"targets": ["10.0.0.1:8080"]}]`)
+
+	d := NewFileDiscoverer(promslog.NewNopLogger(), []string{path}, 10*time.Millisecond)
+	ch := make(chan []Target)
+	go d.Run(t.Context(), ch)
+
+	if got := receive(t, ch); len(got) != 1 || got[0].Address != "10.0.0.1:8080" {
+		t.Errorf("Run() sent %v, want the target of the file", got)
+	}
+
+	writeFile(t, path, `[{"targets": ["10.0.0.1:8080", "10.0.0.2:8080"]}]`)
+	if got := receive(t, ch); len(got) != 2 {
+		t.Errorf("Run() sent %v after the file changed, want 2 targets", got)
+	}
+}
+
+func receive(t *testing.T, ch <-chan []Target) []Target {
+	t.Helper()
+
+	select {
+	case targets := <-ch:
+		return targets
+	case <-time.After(5 * time.Second):
+		t.Fatal("no targets received")
+		return nil
+	}
+}
+
+func writeFile(t *testing.T, path string, content string) {
+	t.Helper()
+
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
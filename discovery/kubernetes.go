@@ -0,0 +1,382 @@
+package discovery
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nginx/nginx-prometheus-exporter/config"
+)
+
+const (
+	// StubStatusPortAnnotation is the pod annotation with the port of the stub_status page.
+	StubStatusPortAnnotation = "nginx.org/stub-status-port"
+	// StubStatusPathAnnotation is the pod annotation with the path of the stub_status page.
+	StubStatusPathAnnotation = "nginx.org/stub-status-path"
+	// PlusAPIPortAnnotation is the pod annotation with the port of the NGINX Plus API.
+	PlusAPIPortAnnotation = "nginx.org/plus-api-port"
+	// PlusAPIPathAnnotation is the pod annotation with the path of the NGINX Plus API.
+	PlusAPIPathAnnotation = "nginx.org/plus-api-path"
+
+	// InClusterTokenFile is the token of the service account of a pod.
+	InClusterTokenFile = "/var/run/secrets/kubernetes.io/serviceaccount/token" // #nosec G101
+	// InClusterCAFile is the CA certificate of the API server mounted into a pod.
+	InClusterCAFile = "/var/run/secrets/kubernetes.io/serviceaccount/ca.crt"
+
+	kubernetesRetryInterval = 5 * time.Second
+	kubernetesWatchTimeout  = 5 * time.Minute
+)
+
+type podMetadata struct {
+	Labels      map[string]string `json:"labels"`
+	Annotations map[string]string `json:"annotations"`
+	Name        string            `json:"name"`
+	Namespace   string            `json:"namespace"`
+}
+
+type pod struct {
+	Status struct {
+		Phase string `json:"phase"`
+		PodIP string `json:"podIP"`
+	} `json:"status"`
+	Metadata podMetadata `json:"metadata"`
+}
+
+type podList struct {
+	Metadata struct {
+		ResourceVersion string `json:"resourceVersion"`
+	} `json:"metadata"`
+	Items []pod `json:"items"`
+}
+
+type watchEvent struct {
+	Type   string          `json:"type"`
+	Object json.RawMessage `json:"object"`
+}
+
+type apiStatus struct {
+	Message string `json:"message"`
+	Code    int    `json:"code"`
+}
+
+// namespaceTargets are the targets of the pods of a single namespace, or of all
+// namespaces if namespace is empty.
+type namespaceTargets struct {
+	namespace string
+	targets   []Target
+}
+
+// KubernetesDiscoverer finds the pods of NGINX and NGINX Plus through the Kubernetes API.
+// A pod is scraped if it is running and has the StubStatusPortAnnotation or the
+// PlusAPIPortAnnotation. A pod with the PlusAPIPortAnnotation is scraped in plus mode.
+// The namespace and name of a pod are added as the namespace and pod labels.
+type KubernetesDiscoverer struct {
+	logger          *slog.Logger
+	httpClient      *http.Client
+	apiServer       string
+	bearerTokenFile string
+	labelSelector   string
+	namespaces      []string
+	podLabels       []string
+	retryInterval   time.Duration
+}
+
+// NewKubernetesDiscoverer creates a KubernetesDiscoverer that uses httpClient to talk to
+// the API server. Without an API server in cfg, the in-cluster API server and service
+// account token are used.
+func NewKubernetesDiscoverer(logger *slog.Logger, httpClient *http.Client, cfg config.KubernetesSDConfig) (*KubernetesDiscoverer, error) {
+	apiServer := cfg.APIServer
+	bearerTokenFile := cfg.APIServerBearerTokenFile
+	if apiServer == "" {
+		host, port := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")
+		if host == "" || port == "" {
+			return nil, errors.New("api_server is required when not running in a Kubernetes cluster")
+		}
+		apiServer = "https://" + net.JoinHostPort(host, port)
+		if bearerTokenFile == "" {
+			bearerTokenFile = InClusterTokenFile
+		}
+	}
+
+	namespaces := cfg.Namespaces
+	if len(namespaces) == 0 {
+		namespaces = []string{""}
+	}
+
+	return &KubernetesDiscoverer{
+		logger:          logger,
+		httpClient:      httpClient,
+		apiServer:       strings.TrimSuffix(apiServer, "/"),
+		bearerTokenFile: bearerTokenFile,
+		labelSelector:   cfg.LabelSelector,
+		namespaces:      namespaces,
+		podLabels:       cfg.PodLabels,
+		retryInterval:   kubernetesRetryInterval,
+	}, nil
+}
+
+// Run implements the Discoverer interface.
+func (d *KubernetesDiscoverer) Run(ctx context.Context, ch chan<- []Target) {
+	updates := make(chan namespaceTargets)
+	for _, namespace := range d.namespaces {
+		go d.watchNamespace(ctx, namespace, updates)
+	}
+
+	byNamespace := make(map[string][]Target, len(d.namespaces))
+	var last []Target
+	sent := false
+	for {
+		select {
+		case update := <-updates:
+			byNamespace[update.namespace] = update.targets
+		case <-ctx.Done():
+			return
+		}
+
+		// wait for the first list of every namespace, so a partial set of targets is never sent
+		if len(byNamespace) < len(d.namespaces) {
+			continue
+		}
+
+		targets := []Target{}
+		for _, namespace := range d.namespaces {
+			targets = append(targets, byNamespace[namespace]...)
+		}
+		if sent && reflect.DeepEqual(targets, last) {
+			continue
+		}
+
+		select {
+		case ch <- targets:
+			last, sent = targets, true
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// watchNamespace lists the pods of namespace and watches them for changes. It sends the
+// targets of the pods every time they change. If listing or watching fails, it starts
+// over after the retry interval.
+func (d *KubernetesDiscoverer) watchNamespace(ctx context.Context, namespace string, updates chan<- namespaceTargets) {
+	for {
+		err := d.listAndWatch(ctx, namespace, updates)
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			d.logger.Error("watching pods failed", "namespace", namespace, "error", err.Error())
+			select {
+			case <-time.After(d.retryInterval):
+			case <-ctx.Done():
+				return
+			}
+		}
+	}
+}
+
+func (d *KubernetesDiscoverer) listAndWatch(ctx context.Context, namespace string, updates chan<- namespaceTargets) error {
+	var list podList
+	resp, err := d.get(ctx, namespace, nil)
+	if err != nil {
+		return err
+	}
+	err = json.NewDecoder(resp.Body).Decode(&list)
+	_ = resp.Body.Close()
+	if err != nil {
+		return fmt.Errorf("failed to decode pod list: %w", err)
+	}
+
+	pods := make(map[string]pod, len(list.Items))
+	for _, p := range list.Items {
+		pods[p.Metadata.Namespace+"/"+p.Metadata.Name] = p
+	}
+	if err := d.send(ctx, namespace, pods, updates); err != nil {
+		return err
+	}
+
+	resp, err = d.get(ctx, namespace, url.Values{
+		"watch":           {"true"},
+		"resourceVersion": {list.Metadata.ResourceVersion},
+		"timeoutSeconds":  {strconv.Itoa(int(kubernetesWatchTimeout.Seconds()))},
+	})
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		var event watchEvent
+		if err := json.Unmarshal(scanner.Bytes(), &event); err != nil {
+			return fmt.Errorf("failed to decode watch event: %w", err)
+		}
+
+		if event.Type == "ERROR" {
+			var status apiStatus
+			_ = json.Unmarshal(event.Object, &status)
+			if status.Code == http.StatusGone {
+				// the resource version is too old, list the pods again
+				return nil
+			}
+			return fmt.Errorf("watch failed with code %d: %s", status.Code, status.Message)
+		}
+
+		var p pod
+		if err := json.Unmarshal(event.Object, &p); err != nil {
+			return fmt.Errorf("failed to decode pod: %w", err)
+		}
+		key := p.Metadata.Namespace + "/" + p.Metadata.Name
+		switch event.Type {
+		case "ADDED", "MODIFIED":
+			pods[key] = p
+		case "DELETED":
+			delete(pods, key)
+		default:
+			continue
+		}
+
+		if err := d.send(ctx, namespace, pods, updates); err != nil {
+			return err
+		}
+	}
+	if err := scanner.Err(); err != nil && ctx.Err() == nil {
+		return fmt.Errorf("failed to read watch events: %w", err)
+	}
+
+	// the API server ended the watch, list the pods again
+	return nil
+}
+
+func (d *KubernetesDiscoverer) get(ctx context.Context, namespace string, params url.Values) (*http.Response, error) {
+	path := "/api/v1/pods"
+	if namespace != "" {
+		path = "/api/v1/namespaces/" + url.PathEscape(namespace) + "/pods"
+	}
+	if params == nil {
+		params = url.Values{}
+	}
+	if d.labelSelector != "" {
+		params.Set("labelSelector", d.labelSelector)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, d.apiServer+path+"?"+params.Encode(), nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Accept", "application/json")
+
+	if d.bearerTokenFile != "" {
+		// the token is read for every request, as it is rotated by Kubernetes
+		token, err := os.ReadFile(d.bearerTokenFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read bearer token file: %w", err)
+		}
+		req.Header.Set("Authorization", "Bearer "+strings.TrimSpace(string(token)))
+	}
+
+	resp, err := d.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get pods: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		_ = resp.Body.Close()
+		return nil, fmt.Errorf("failed to get pods: expected %v response, got %v", http.StatusOK, resp.StatusCode)
+	}
+	return resp, nil
+}
+
+func (d *KubernetesDiscoverer) send(ctx context.Context, namespace string, pods map[string]pod, updates chan<- namespaceTargets) error {
+	keys := make([]string, 0, len(pods))
+	for key := range pods {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+
+	targets := []Target{}
+	for _, key := range keys {
+		target, ok, err := d.podTarget(pods[key])
+		if err != nil {
+			d.logger.Error("skipping pod", "pod", key, "error", err.Error())
+			continue
+		}
+		if ok {
+			targets = append(targets, target)
+		}
+	}
+
+	select {
+	case updates <- namespaceTargets{namespace: namespace, targets: targets}:
+		return nil
+	case <-ctx.Done():
+		return context.Canceled
+	}
+}
+
+// podTarget returns the target of p. It returns false if p is not running or does not
+// have one of the port annotations.
+func (d *KubernetesDiscoverer) podTarget(p pod) (Target, bool, error) {
+	if p.Status.Phase != "Running" || p.Status.PodIP == "" {
+		return Target{}, false, nil
+	}
+
+	labels := map[string]string{
+		"namespace": p.Metadata.Namespace,
+		"pod":       p.Metadata.Name,
+	}
+
+	annotations := p.Metadata.Annotations
+	port, path := annotations[StubStatusPortAnnotation], annotations[StubStatusPathAnnotation]
+	labels[ModeLabel] = config.ModeOSS
+	if plusPort, ok := annotations[PlusAPIPortAnnotation]; ok {
+		port, path = plusPort, annotations[PlusAPIPathAnnotation]
+		labels[ModeLabel] = config.ModePlus
+	}
+	if port == "" {
+		return Target{}, false, nil
+	}
+	if n, err := strconv.ParseUint(port, 10, 16); err != nil || n == 0 {
+		return Target{}, false, fmt.Errorf("invalid port %q", port)
+	}
+	if path != "" {
+		labels[MetricsPathLabel] = path
+	}
+
+	for _, name := range d.podLabels {
+		if value, ok := p.Metadata.Labels[name]; ok {
+			labels[sanitizeLabelName(name)] = value
+		}
+	}
+
+	return Target{
+		Address: net.JoinHostPort(p.Status.PodIP, port),
+		Labels:  labels,
+	}, true, nil
+}
+
+// sanitizeLabelName replaces the characters of a Kubernetes label name that are not
+// valid in a Prometheus label name with underscores.
+func sanitizeLabelName(name string) string {
+	sanitized := []byte(name)
+	for i, c := range sanitized {
+		isLetter := c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c == '_'
+		isDigit := c >= '0' && c <= '9'
+		if !isLetter && (!isDigit || i == 0) {
+			sanitized[i] = '_'
+		}
+	}
+	return string(sanitized)
+}
@@ -0,0 +1,111 @@
+package discovery
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/nginx/nginx-prometheus-exporter/config"
+	"github.com/prometheus/common/promslog"
+)
+
+const podTemplate = `{"metadata": {"name": %q, "namespace": "nginx", "labels": {"app": "web", "app.kubernetes.io/version": "1.2"}, "annotations": %s}, "status": {"phase": %q, "podIP": %q}}`
+
+func TestKubernetesDiscoverer(t *testing.T) {
+	t.Parallel()
+
+	stubStatus := `{"nginx.org/stub-status-port": "8080"}`
+	plusAPI := `{"nginx.org/plus-api-port": "8443", "nginx.org/plus-api-path": "/api/9"}`
+
+	apiServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer test-token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if r.URL.Path != "/api/v1/namespaces/nginx/pods" || r.URL.Query().Get("labelSelector") != "app=web" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		if r.URL.Query().Get("watch") != "true" {
+			fmt.Fprintf(w, `{"metadata": {"resourceVersion": "100"}, "items": [%s, %s, %s, %s]}`,
+				fmt.Sprintf(podTemplate, "web-1", stubStatus, "Running", "10.0.0.1"),
+				fmt.Sprintf(podTemplate, "plus-1", plusAPI, "Running", "10.0.0.2"),
+				fmt.Sprintf(podTemplate, "pending-1", stubStatus, "Pending", ""),
+				fmt.Sprintf(podTemplate, "other-1", "{}", "Running", "10.0.0.4"),
+			)
+			return
+		}
+
+		if r.URL.Query().Get("resourceVersion") != "100" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		fmt.Fprintf(w, "{\"type\": \"DELETED\", \"object\": %s}\n", fmt.Sprintf(podTemplate, "web-1", stubStatus, "Running", "10.0.0.1"))
+		fmt.Fprintf(w, "{\"type\": \"ADDED\", \"object\": %s}\n", fmt.Sprintf(podTemplate, "web-2", stubStatus, "Running", "10.0.0.5"))
+		w.(http.Flusher).Flush()
+		<-r.Context().Done()
+	}))
+	t.Cleanup(apiServer.Close)
+
+	tokenFile := filepath.Join(t.TempDir(), "token")
+	writeFile(t, tokenFile, "test-token\n")
+
+	d, err := NewKubernetesDiscoverer(promslog.NewNopLogger(), apiServer.Client(), config.KubernetesSDConfig{
+		APIServer:                apiServer.URL,
+		APIServerBearerTokenFile: tokenFile,
+		LabelSelector:            "app=web",
+		Namespaces:               []string{"nginx"},
+		PodLabels:                []string{"app.kubernetes.io/version"},
+	})
+	if err != nil {
+		t.Fatalf("NewKubernetesDiscoverer() returned error: %v", err)
+	}
+
+	ch := make(chan []Target)
+	go d.Run(t.Context(), ch)
+
+	podTarget := func(name, address, mode string) Target {
+		return Target{
+			Address: address,
+			Labels: map[string]string{
+				"namespace":                 "nginx",
+				"pod":                       name,
+				"app_kubernetes_io_version": "1.2",
+				ModeLabel:                   mode,
+			},
+		}
+	}
+	plusTarget := podTarget("plus-1", "10.0.0.2:8443", config.ModePlus)
+	plusTarget.Labels[MetricsPathLabel] = "/api/9"
+
+	want := []Target{plusTarget, podTarget("web-1", "10.0.0.1:8080", config.ModeOSS)}
+	if got := receive(t, ch); !reflect.DeepEqual(got, want) {
+		t.Errorf("Run() sent %v, want %v", got, want)
+	}
+
+	want = []Target{plusTarget, podTarget("web-2", "10.0.0.5:8080", config.ModeOSS)}
+	for got := receive(t, ch); !reflect.DeepEqual(got, want); got = receive(t, ch) {
+		if len(got) != 1 {
+			t.Fatalf("Run() sent %v, want %v", got, want)
+		}
+	}
+}
+
+func TestSanitizeLabelName(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]string{
+		"app":                       "app",
+		"app.kubernetes.io/version": "app_kubernetes_io_version",
+		"1st-label":                 "_st_label",
+	}
+	for name, want := range tests {
+		if got := sanitizeLabelName(name); got != want {
+			t.Errorf("sanitizeLabelName(%q) = %q, want %q", name, got, want)
+		}
+	}
+}
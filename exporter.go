@@ -2,14 +2,13 @@ package main
 
 import (
 	"context"
-	"crypto/tls"
-	"crypto/x509"
 	"errors"
 	"fmt"
 	"log/slog"
-	"maps"
 	"net"
 	"net/http"
+	"net/netip"
+	"net/url"
 	"os"
 	"os/signal"
 	"strings"
@@ -19,6 +18,7 @@ import (
 	plusclient "github.com/nginx/nginx-plus-go-client/v3/client"
 	"github.com/nginx/nginx-prometheus-exporter/client"
 	"github.com/nginx/nginx-prometheus-exporter/collector"
+	"github.com/nginx/nginx-prometheus-exporter/config"
 
 	"github.com/alecthomas/kingpin/v2"
 	"github.com/prometheus/client_golang/prometheus"
@@ -32,6 +32,7 @@ import (
 	"github.com/prometheus/exporter-toolkit/web/kingpinflag"
 
 	proxyproto "github.com/pires/go-proxyproto"
+	"golang.org/x/net/proxy"
 )
 
 // positiveDuration is a wrapper of time.Duration to ensure only positive values are accepted.
@@ -59,9 +60,9 @@ func parsePositiveDuration(s string) (positiveDuration, error) {
 }
 
 func createPositiveDurationFlag(s kingpin.Settings) (target *time.Duration) {
-	target = new(time.Duration)
-	s.SetValue(&positiveDuration{Duration: *target})
-	return target
+	value := &positiveDuration{}
+	s.SetValue(value)
+	return &value.Duration
 }
 
 func parseUnixSocketAddress(address string) (string, string, error) {
@@ -80,22 +81,72 @@ func parseUnixSocketAddress(address string) (string, string, error) {
 	return unixSocketPath, requestPath, nil
 }
 
+// parseUnixSocketURL parses the http+unix or https+unix URL of a unix domain socket,
+// http+unix://[host]/<socket path>:<request path>, and returns the socket path and the
+// URL to request through the socket. Colons in the socket path are escaped as %3A, and
+// the name of an abstract socket starts with @, as in http+unix:///@nginx:/stub_status.
+// The host is sent in the Host header and, for https+unix, as the TLS server name.
+func parseUnixSocketURL(address string) (string, string, error) {
+	u, err := url.Parse(address)
+	if err != nil {
+		return "", "", fmt.Errorf("parsing URL failed: %w", err)
+	}
+	scheme, ok := strings.CutSuffix(u.Scheme, "+unix")
+	if !ok || (scheme != "http" && scheme != "https") {
+		return "", "", fmt.Errorf("unsupported scheme %q", u.Scheme)
+	}
+	if u.User != nil {
+		return "", "", errors.New("user info is not supported")
+	}
+
+	escapedSocketPath, requestPath, _ := strings.Cut(u.EscapedPath(), ":")
+	socketPath, err := url.PathUnescape(escapedSocketPath)
+	if err != nil {
+		return "", "", fmt.Errorf("parsing socket path failed: %w", err)
+	}
+	if socketPath == "" || socketPath == "/" {
+		return "", "", errors.New("socket path is missing")
+	}
+	if strings.HasPrefix(socketPath, "/@") {
+		socketPath = socketPath[1:]
+	}
+	if requestPath != "" && !strings.HasPrefix(requestPath, "/") {
+		return "", "", fmt.Errorf("request path %q does not start with /", requestPath)
+	}
+
+	host := u.Host
+	if host == "" {
+		host = "unix"
+	}
+	endpoint := scheme + "://" + host + requestPath
+	if u.RawQuery != "" {
+		endpoint += "?" + u.RawQuery
+	}
+	return socketPath, endpoint, nil
+}
+
 var (
 	constLabels = map[string]string{}
 
 	// Command-line flags.
 	webConfig     = kingpinflag.AddFlags(kingpin.CommandLine, ":9113")
 	metricsPath   = kingpin.Flag("web.telemetry-path", "Path under which to expose metrics.").Default("/metrics").Envar("TELEMETRY_PATH").String()
+	lifecycle     = kingpin.Flag("web.enable-lifecycle", "Enable reloading the configuration file through HTTP requests to /-/reload.").Default("false").Envar("ENABLE_LIFECYCLE").Bool()
 	nginxPlus     = kingpin.Flag("nginx.plus", "Start the exporter for NGINX Plus. By default, the exporter is started for NGINX.").Default("false").Envar("NGINX_PLUS").Bool()
-	scrapeURIs    = kingpin.Flag("nginx.scrape-uri", "A URI or unix domain socket path for scraping NGINX or NGINX Plus metrics. For NGINX, the stub_status page must be available through the URI. For NGINX Plus -- the API. Repeatable for multiple URIs.").Default("http://127.0.0.1:8080/stub_status").Envar("SCRAPE_URI").HintOptions("http://127.0.0.1:8080/stub_status", "http://127.0.0.1:8080/api").Strings()
+	scrapeURIs    = kingpin.Flag("nginx.scrape-uri", "A URI or unix domain socket URL (http+unix:///path/to/socket:/stub_status) for scraping NGINX or NGINX Plus metrics. For NGINX, the stub_status page must be available through the URI. For NGINX Plus -- the API. Repeatable for multiple URIs.").Default("http://127.0.0.1:8080/stub_status").Envar("SCRAPE_URI").HintOptions("http://127.0.0.1:8080/stub_status", "http://127.0.0.1:8080/api").Strings()
 	sslVerify     = kingpin.Flag("nginx.ssl-verify", "Perform SSL certificate verification.").Default("false").Envar("SSL_VERIFY").Bool()
 	sslCaCert     = kingpin.Flag("nginx.ssl-ca-cert", "Path to the PEM encoded CA certificate file used to validate the servers SSL certificate.").Default("").Envar("SSL_CA_CERT").String()
 	sslClientCert = kingpin.Flag("nginx.ssl-client-cert", "Path to the PEM encoded client certificate file to use when connecting to the server.").Default("").Envar("SSL_CLIENT_CERT").String()
 	sslClientKey  = kingpin.Flag("nginx.ssl-client-key", "Path to the PEM encoded client certificate key file to use when connecting to the server.").Default("").Envar("SSL_CLIENT_KEY").String()
 	useProxyProto = kingpin.Flag("nginx.proxy-protocol", "Pass proxy protocol payload to nginx listeners.").Default("false").Envar("PROXY_PROTOCOL").Bool()
+	retryAttempts = kingpin.Flag("nginx.retry-attempts", "Maximum number of attempts of a request to NGINX or NGINX Plus that fails with a network error or a 5xx response, including the first one. No attempt is made after the timeout.").Default("1").Envar("RETRY_ATTEMPTS").Int()
+	configFile    = kingpin.Flag("config.file", "Path to the configuration file with the scrape targets and the modules used by the /probe endpoint. Targets in the file replace the targets given by the --nginx.* flags.").Default("").Envar("EXPORTER_CONFIG_FILE").String()
 
 	// Custom command-line flags.
-	timeout = createPositiveDurationFlag(kingpin.Flag("nginx.timeout", "A timeout for scraping metrics from NGINX or NGINX Plus.").Default("5s").Envar("TIMEOUT").HintOptions("5s", "10s", "30s", "1m", "5m"))
+	timeout          = createPositiveDurationFlag(kingpin.Flag("nginx.timeout", "A timeout for scraping metrics from NGINX or NGINX Plus.").Default("5s").Envar("TIMEOUT").HintOptions("5s", "10s", "30s", "1m", "5m"))
+	pollInterval     = createPositiveDurationFlag(kingpin.Flag("nginx.poll-interval", "Poll NGINX or NGINX Plus in the background at this interval and serve the metrics of the last poll, instead of requesting NGINX on every scrape. Disabled if 0.").Default("0s").Envar("POLL_INTERVAL").HintOptions("5s", "10s", "30s"))
+	staleGracePeriod = createPositiveDurationFlag(kingpin.Flag("nginx.stale-grace-period", "Serve the metrics of the last successful scrape for this long when scraping NGINX or NGINX Plus fails. The up metric still reports the failure. Disabled if 0.").Default("0s").Envar("STALE_GRACE_PERIOD").HintOptions("1m", "5m"))
+	retryBackoff     = createPositiveDurationFlag(kingpin.Flag("nginx.retry-backoff", "Wait before the first retry of a request to NGINX or NGINX Plus, doubled for every further retry up to 1s or this wait if longer.").Default("100ms").Envar("RETRY_BACKOFF").HintOptions("100ms", "500ms"))
 )
 
 const exporterName = "nginx_exporter"
@@ -112,70 +163,40 @@ func main() {
 		}
 	}
 
-	config := &promslog.Config{}
+	promslogConfig := &promslog.Config{}
 
-	flag.AddFlags(kingpin.CommandLine, config)
+	flag.AddFlags(kingpin.CommandLine, promslogConfig)
 	kingpin.Version(common_version.Print(exporterName))
 	kingpin.HelpFlag.Short('h')
 
 	addMissingEnvironmentFlags(kingpin.CommandLine)
 
 	kingpin.Parse()
-	logger := promslog.New(config)
+	logger := promslog.New(promslogConfig)
 
 	logger.Info("nginx-prometheus-exporter", "version", common_version.Info())
 	logger.Info("build context", "build_context", common_version.BuildContext())
 
 	prometheus.MustRegister(version.NewCollector(exporterName))
 
-	if len(*scrapeURIs) == 0 {
-		logger.Error("no scrape addresses provided")
+	reloader := newConfigReloader(logger, prometheus.DefaultRegisterer, loadConfig)
+	if err := reloader.reload(); err != nil {
+		logger.Error("loading config failed", "error", err.Error())
 		os.Exit(1)
 	}
 
-	// #nosec G402
-	sslConfig := &tls.Config{InsecureSkipVerify: !*sslVerify}
-	if *sslCaCert != "" {
-		caCert, err := os.ReadFile(*sslCaCert)
-		if err != nil {
-			logger.Error("loading CA cert failed", "err", err.Error())
-			os.Exit(1)
-		}
-		sslCaCertPool := x509.NewCertPool()
-		ok := sslCaCertPool.AppendCertsFromPEM(caCert)
-		if !ok {
-			logger.Error("parsing CA cert file failed.")
-			os.Exit(1)
-		}
-		sslConfig.RootCAs = sslCaCertPool
-	}
-
-	if *sslClientCert != "" && *sslClientKey != "" {
-		clientCert, err := tls.LoadX509KeyPair(*sslClientCert, *sslClientKey)
-		if err != nil {
-			logger.Error("loading client certificate failed", "error", err.Error())
-			os.Exit(1)
-		}
-		sslConfig.Certificates = []tls.Certificate{clientCert}
-	}
-
-	transport := &http.Transport{
-		TLSClientConfig: sslConfig,
-	}
-
-	if len(*scrapeURIs) == 1 {
-		registerCollector(logger, transport, (*scrapeURIs)[0], constLabels)
-	} else {
-		for _, addr := range *scrapeURIs {
-			// add scrape URI to const labels
-			labels := maps.Clone(constLabels)
-			labels["addr"] = addr
-
-			registerCollector(logger, transport, addr, labels)
-		}
-	}
-
 	http.Handle(*metricsPath, promhttp.Handler())
+	http.Handle("/probe", &probeHandler{
+		logger:        logger,
+		modules:       reloader.modules,
+		defaultModule: flagModule(),
+		constLabels:   constLabels,
+	})
+	// like in Prometheus, reloads over HTTP are opt-in, as anyone who can reach the
+	// exporter could trigger them
+	if *lifecycle {
+		http.Handle("/-/reload", reloader)
+	}
 
 	if *metricsPath != "/" && *metricsPath != "" {
 		landingConfig := web.LandingConfig{
@@ -201,6 +222,18 @@ func main() {
 	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, os.Kill, syscall.SIGTERM)
 	defer cancel()
 
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for range hup {
+			if err := reloader.reload(); err != nil {
+				logger.Error("reloading config failed", "error", err.Error())
+				continue
+			}
+			logger.Info("reloaded config")
+		}
+	}()
+
 	srv := &http.Server{
 		ReadHeaderTimeout: 5 * time.Second,
 	}
@@ -223,105 +256,264 @@ func main() {
 	_ = srv.Shutdown(srvCtx)
 }
 
-func registerCollector(logger *slog.Logger, transport *http.Transport,
-	addr string, labels map[string]string,
-) {
+// loadConfig reads the configuration file given by the --config.file flag. Without the
+// flag, the configuration is empty and the targets are taken from the --nginx.* flags.
+func loadConfig() (*config.Config, error) {
+	if *configFile == "" {
+		return &config.Config{}, nil
+	}
+	return config.Load(*configFile)
+}
+
+// flagModule returns the scrape settings given by the command-line flags.
+func flagModule() config.Module {
+	mode := config.ModeOSS
+	if *nginxPlus {
+		mode = config.ModePlus
+	}
+
+	return config.Module{
+		Mode:             mode,
+		Timeout:          *timeout,
+		PollInterval:     *pollInterval,
+		StaleGracePeriod: *staleGracePeriod,
+		ProxyProtocol:    config.ProxyProtocol{Enabled: *useProxyProto, Version: 2},
+		Retry: config.Retry{
+			Attempts:       *retryAttempts,
+			InitialBackoff: *retryBackoff,
+			MaxBackoff:     max(config.DefaultRetryMaxBackoff, *retryBackoff),
+		},
+		TLSConfig: config.TLSConfig{
+			CAFile:             *sslCaCert,
+			CertFile:           *sslClientCert,
+			KeyFile:            *sslClientKey,
+			InsecureSkipVerify: !*sslVerify,
+		},
+	}
+}
+
+// flagTargets returns the scrape targets given by the command-line flags.
+func flagTargets() []config.Target {
+	targets := make([]config.Target, 0, len(*scrapeURIs))
+	for _, uri := range *scrapeURIs {
+		targets = append(targets, config.Target{
+			Name:   uri,
+			URI:    uri,
+			Module: flagModule(),
+		})
+	}
+	return targets
+}
+
+// targetLabels returns the const labels of the collector for target. The scrape URI is
+// added as the addr label if the exporter scrapes more than one target.
+func targetLabels(target config.Target, multiTarget bool) map[string]string {
+	labels := collector.MergeLabels(constLabels, nil)
+	if multiTarget {
+		// add scrape URI to const labels
+		labels["addr"] = target.URI
+	}
+	return collector.MergeLabels(labels, target.Labels)
+}
+
+// newHTTPClient creates the HTTP client used to scrape addr. Every client gets its own
+// transport, dialer and TLS config, so the settings of one scrape target never leak into
+// another. It returns the client along with the URL of the NGINX endpoint to request.
+func newHTTPClient(addr string, module config.Module) (*http.Client, string, error) {
+	files, err := newTLSFiles(module.TLSConfig)
+	if err != nil {
+		return nil, "", err
+	}
+	return newHTTPClientWithTLS(addr, module, files)
+}
+
+// newHTTPClientWithTLS is like newHTTPClient, with the TLS files of the module already
+// loaded, so the caller can tell the expiry of the client certificate.
+func newHTTPClientWithTLS(addr string, module config.Module, files *tlsFiles) (*http.Client, string, error) {
 	var socketPath string
+	var err error
 
-	if strings.HasPrefix(addr, "unix:") {
-		var err error
+	switch {
+	case strings.HasPrefix(addr, "unix:"):
 		var requestPath string
 		socketPath, requestPath, err = parseUnixSocketAddress(addr)
 		if err != nil {
-			logger.Error("parsing unix domain socket scrape address failed", "uri", addr, "error", err.Error())
-			os.Exit(1)
+			return nil, "", fmt.Errorf("parsing unix domain socket scrape address failed: %w", err)
 		}
 		addr = "http://unix" + requestPath
+	case strings.HasPrefix(addr, "http+unix:"), strings.HasPrefix(addr, "https+unix:"):
+		socketPath, addr, err = parseUnixSocketURL(addr)
+		if err != nil {
+			return nil, "", fmt.Errorf("parsing unix domain socket scrape URL failed: %w", err)
+		}
 	}
 
-	if !*useProxyProto && socketPath != "" {
-		transport.DialContext = func(ctx context.Context, _, _ string) (net.Conn, error) {
-			d := &net.Dialer{}
-			return d.DialContext(ctx, "unix", socketPath)
+	dialer := &net.Dialer{}
+	var proxyDialer proxy.ContextDialer
+	if module.ProxyURL != "" {
+		if socketPath != "" {
+			return nil, "", errors.New("proxy_url cannot be used with a unix domain socket")
+		}
+		if proxyDialer, err = newProxyDialer(module.ProxyURL, dialer); err != nil {
+			return nil, "", err
 		}
 	}
 
-	if *useProxyProto {
-		transport.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
-			if socketPath != "" {
-				network = "unix"
-				addr = socketPath
-			}
+	dialContext := newDialContext(dialer, socketPath, proxyDialer, module.ProxyProtocol)
+	transport := &http.Transport{
+		DialContext:    dialContext,
+		DialTLSContext: newDialTLSContext(dialContext, files),
+	}
 
-			conn, err := (&net.Dialer{}).DialContext(ctx, network, addr)
-			if err != nil {
-				return nil, fmt.Errorf("dialing %s %s: %w", network, addr, err)
-			}
+	authTransport, err := newAuthRoundTripper(module, transport)
+	if err != nil {
+		return nil, "", err
+	}
 
-			localAddr := conn.LocalAddr()
-			remoteAddr := conn.RemoteAddr()
-			transportProtocol := proxyproto.TCPv4
-
-			switch remoteAddrTyped := remoteAddr.(type) {
-			case *net.TCPAddr:
-				if remoteAddrTyped.IP.To4() == nil {
-					transportProtocol = proxyproto.TCPv6
-				}
-			case *net.UnixAddr:
-				transportProtocol = proxyproto.UnixStream
-			}
+	userAgent := fmt.Sprintf("NGINX-Prometheus-Exporter/v%v", common_version.Version)
 
-			header := &proxyproto.Header{
-				Version:           2,
-				Command:           proxyproto.PROXY,
-				TransportProtocol: transportProtocol,
-				SourceAddr:        localAddr,
-				DestinationAddr:   remoteAddr,
-			}
+	httpClient := &http.Client{
+		Timeout: module.Timeout,
+		Transport: &userAgentRoundTripper{
+			agent:   userAgent,
+			headers: module.Headers,
+			rt:      authTransport,
+		},
+	}
 
-			// as we do not use any TLVs, header size should be pretty small, hence we only check for error, assuming the whole header went out in a single packet
-			_, err = header.WriteTo(conn)
-			if err != nil {
-				conn.Close()
-				return nil, fmt.Errorf("writing proxyproto header via %s to %s: %w", network, addr, err)
-			}
+	return httpClient, addr, nil
+}
 
+// newDialContext returns the dial function of a single scrape target. Connections go to
+// socketPath instead of the requested address if it is set, or through proxyDialer if it
+// is not nil, and start with a PROXY protocol header if enabled in proxyProto. The header
+// comes before any TLS handshake, so it also works for HTTPS scrape URIs.
+func newDialContext(dialer *net.Dialer, socketPath string, proxyDialer proxy.ContextDialer, proxyProto config.ProxyProtocol) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		var conn net.Conn
+		var err error
+		switch {
+		case socketPath != "":
+			network = "unix"
+			addr = socketPath
+			conn, err = dialer.DialContext(ctx, network, addr)
+		case proxyDialer != nil:
+			conn, err = proxyDialer.DialContext(ctx, network, addr)
+		default:
+			conn, err = dialer.DialContext(ctx, network, addr)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("dialing %s %s: %w", network, addr, err)
+		}
+
+		if !proxyProto.Enabled {
 			return conn, nil
 		}
+
+		sourceAddr, destinationAddr := conn.LocalAddr(), conn.RemoteAddr()
+		if proxyProto.SourceAddress != "" {
+			sourceAddr = proxyProtocolSourceAddr(proxyProto.SourceAddress, sourceAddr)
+		}
+		if proxyDialer != nil {
+			// the connection goes to the proxy, so the destination is the requested
+			// address, which results in a LOCAL header if it is not an IP address
+			destinationAddr = nil
+			if addrPort, err := netip.ParseAddrPort(addr); err == nil {
+				destinationAddr = net.TCPAddrFromAddrPort(addrPort)
+			}
+		}
+		header := proxyproto.HeaderProxyFromAddrs(byte(proxyProto.Version), sourceAddr, destinationAddr)
+		if err := header.SetTLVs(proxyProtocolTLVs(proxyProto.TLVs)); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("setting proxyproto TLVs failed: %w", err)
+		}
+
+		_, err = header.WriteTo(conn)
+		if err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("writing proxyproto header via %s to %s: %w", network, addr, err)
+		}
+
+		return conn, nil
 	}
+}
 
-	userAgent := fmt.Sprintf("NGINX-Prometheus-Exporter/v%v", common_version.Version)
+// proxyProtocolSourceAddr returns the configured source address of a PROXY protocol
+// header, an IP address with an optional port. Without a port, the port of the actual
+// source address is kept.
+func proxyProtocolSourceAddr(configured string, actual net.Addr) net.Addr {
+	if addrPort, err := netip.ParseAddrPort(configured); err == nil {
+		return net.TCPAddrFromAddrPort(addrPort)
+	}
+	addr := &net.TCPAddr{IP: net.ParseIP(configured)}
+	if tcpAddr, ok := actual.(*net.TCPAddr); ok {
+		addr.Port = tcpAddr.Port
+	}
+	return addr
+}
 
-	httpClient := &http.Client{
-		Timeout: *timeout,
-		Transport: &userAgentRoundTripper{
-			agent: userAgent,
-			rt:    transport,
-		},
+// proxyProtocolTLVs returns the TLVs of a PROXY protocol header.
+func proxyProtocolTLVs(cfg config.ProxyProtocolTLVs) []proxyproto.TLV {
+	var tlvs []proxyproto.TLV
+	for _, tlv := range []struct {
+		value   string
+		tlvType proxyproto.PP2Type
+	}{
+		{value: cfg.ALPN, tlvType: proxyproto.PP2_TYPE_ALPN},
+		{value: cfg.Authority, tlvType: proxyproto.PP2_TYPE_AUTHORITY},
+		{value: cfg.UniqueID, tlvType: proxyproto.PP2_TYPE_UNIQUE_ID},
+	} {
+		if tlv.value != "" {
+			tlvs = append(tlvs, proxyproto.TLV{Type: tlv.tlvType, Value: []byte(tlv.value)})
+		}
 	}
+	return tlvs
+}
 
-	if *nginxPlus {
-		plusClient, err := plusclient.NewNginxClient(addr, plusclient.WithHTTPClient(httpClient))
+func newCollector(logger *slog.Logger, httpClient *http.Client,
+	endpoint string, module config.Module, labels map[string]string,
+) (collector.Scraper, error) {
+	opts := []collector.Option{
+		collector.WithPollInterval(module.PollInterval),
+		collector.WithStaleGracePeriod(module.StaleGracePeriod),
+	}
+
+	if module.Mode == config.ModePlus {
+		plusClient, err := plusclient.NewNginxClient(endpoint, plusclient.WithHTTPClient(httpClient))
 		if err != nil {
-			logger.Error("could not create Nginx Plus Client", "error", err.Error())
-			os.Exit(1)
+			return nil, fmt.Errorf("could not create Nginx Plus Client: %w", err)
+		}
+		httpKeys, streamKeys, err := module.KeyvalValues.KeyPatterns()
+		if err != nil {
+			return nil, fmt.Errorf("could not select keyval keys: %w", err)
+		}
+		if len(httpKeys)+len(streamKeys) > 0 {
+			opts = append(opts, collector.WithKeyvalValues(httpKeys, streamKeys, module.KeyvalValues.MaxKeysPerZone))
 		}
 		variableLabelNames := collector.NewVariableLabelNames(nil, nil, nil, nil, nil, nil, nil)
-		prometheus.MustRegister(collector.NewNginxPlusCollector(plusClient, "nginxplus", variableLabelNames, labels, logger))
-	} else {
-		ossClient := client.NewNginxClient(httpClient, addr)
-		prometheus.MustRegister(collector.NewNginxCollector(ossClient, "nginx", labels, logger))
+		return collector.NewNginxPlusCollector(plusClient, "nginxplus", variableLabelNames, labels, logger, opts...), nil
 	}
+
+	ossClient := client.NewNginxClient(httpClient, endpoint)
+	return collector.NewNginxCollector(ossClient, "nginx", labels, logger, opts...), nil
 }
 
 type userAgentRoundTripper struct {
-	rt    http.RoundTripper
-	agent string
+	rt      http.RoundTripper
+	headers map[string]string
+	agent   string
 }
 
 func (rt *userAgentRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
 	req = cloneRequest(req)
 	req.Header.Set("User-Agent", rt.agent)
+	for name, value := range rt.headers {
+		if http.CanonicalHeaderKey(name) == "Host" {
+			req.Host = value
+			continue
+		}
+		req.Header.Set(name, value)
+	}
 	roundTrip, err := rt.rt.RoundTrip(req)
 	if err != nil {
 		return nil, fmt.Errorf("round trip failed: %w", err)
@@ -329,6 +521,13 @@ func (rt *userAgentRoundTripper) RoundTrip(req *http.Request) (*http.Response, e
 	return roundTrip, nil
 }
 
+// CloseIdleConnections closes the idle connections of the wrapped transport.
+func (rt *userAgentRoundTripper) CloseIdleConnections() {
+	if closer, ok := rt.rt.(interface{ CloseIdleConnections() }); ok {
+		closer.CloseIdleConnections()
+	}
+}
+
 func cloneRequest(req *http.Request) *http.Request {
 	r := new(http.Request)
 	*r = *req // shallow clone
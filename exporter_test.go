@@ -1,11 +1,22 @@
 package main
 
 import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
 	"reflect"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/alecthomas/kingpin/v2"
+	"github.com/nginx/nginx-prometheus-exporter/config"
+	proxyproto "github.com/pires/go-proxyproto"
 	"github.com/prometheus/exporter-toolkit/web/kingpinflag"
 )
 
@@ -52,6 +63,32 @@ func TestParsePositiveDuration(t *testing.T) {
 	}
 }
 
+func TestCreatePositiveDurationFlag(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name string
+		args []string
+		want time.Duration
+	}{
+		{name: "default", args: nil, want: 5 * time.Second},
+		{name: "flag", args: []string{"--timeout=10s"}, want: 10 * time.Second},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			app := kingpin.New("test", "")
+			got := createPositiveDurationFlag(app.Flag("timeout", "").Default("5s"))
+			if _, err := app.Parse(tt.args); err != nil {
+				t.Fatalf("Parse() returned error: %v", err)
+			}
+			if *got != tt.want {
+				t.Errorf("createPositiveDurationFlag() = %v, want %v", *got, tt.want)
+			}
+		})
+	}
+}
+
 func TestParseUnixSocketAddress(t *testing.T) {
 	t.Parallel()
 
@@ -109,6 +146,80 @@ func TestParseUnixSocketAddress(t *testing.T) {
 	}
 }
 
+func TestParseUnixSocketURL(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name           string
+		testInput      string
+		wantSocketPath string
+		wantEndpoint   string
+		wantErr        bool
+	}{
+		{
+			name:           "http unix socket URL",
+			testInput:      "http+unix:///run/nginx.sock:/stub_status",
+			wantSocketPath: "/run/nginx.sock",
+			wantEndpoint:   "http://unix/stub_status",
+		},
+		{
+			name:           "https unix socket URL with host and query",
+			testInput:      "https+unix://nginx.example.com/run/nginx.sock:/api?fields=nginx",
+			wantSocketPath: "/run/nginx.sock",
+			wantEndpoint:   "https://nginx.example.com/api?fields=nginx",
+		},
+		{
+			name:           "unix socket URL without request path",
+			testInput:      "http+unix:///run/nginx.sock",
+			wantSocketPath: "/run/nginx.sock",
+			wantEndpoint:   "http://unix",
+		},
+		{
+			name:           "socket path with escaped colons",
+			testInput:      "http+unix:///run/nginx%3A8080.sock:/stub_status:80",
+			wantSocketPath: "/run/nginx:8080.sock",
+			wantEndpoint:   "http://unix/stub_status:80",
+		},
+		{
+			name:           "abstract socket",
+			testInput:      "http+unix:///@nginx:/stub_status",
+			wantSocketPath: "@nginx",
+			wantEndpoint:   "http://unix/stub_status",
+		},
+		{
+			name:      "missing socket path",
+			testInput: "http+unix://nginx.example.com",
+			wantErr:   true,
+		},
+		{
+			name:      "request path without slash",
+			testInput: "http+unix:///run/nginx.sock:stub_status",
+			wantErr:   true,
+		},
+		{
+			name:      "unsupported scheme",
+			testInput: "ftp+unix:///run/nginx.sock:/stub_status",
+			wantErr:   true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			socketPath, endpoint, err := parseUnixSocketURL(tt.testInput)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("parseUnixSocketURL() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if socketPath != tt.wantSocketPath {
+				t.Errorf("socket path: parseUnixSocketURL() = %v, want %v", socketPath, tt.wantSocketPath)
+			}
+			if endpoint != tt.wantEndpoint {
+				t.Errorf("endpoint: parseUnixSocketURL() = %v, want %v", endpoint, tt.wantEndpoint)
+			}
+		})
+	}
+}
+
 func TestAddMissingEnvironmentFlags(t *testing.T) {
 	t.Parallel()
 	expectedMatches := map[string]string{
@@ -168,3 +279,242 @@ func TestConvertFlagToEnvar(t *testing.T) {
 		}
 	}
 }
+
+func TestNewHTTPClientMixedTargets(t *testing.T) {
+	t.Parallel()
+
+	// plain TCP listener
+	tcpServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = io.WriteString(w, "tcp")
+	}))
+	t.Cleanup(tcpServer.Close)
+
+	// TCP listener that expects a PROXY protocol header
+	proxyListener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	proxyServer := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, "proxy "+r.RemoteAddr)
+	}))
+	proxyServer.Listener = &proxyproto.Listener{Listener: proxyListener}
+	proxyServer.Start()
+	t.Cleanup(proxyServer.Close)
+
+	// unix domain socket listener
+	socketPath := filepath.Join(t.TempDir(), "nginx.sock")
+	unixListener, err := net.Listen("unix", socketPath)
+	if err != nil {
+		t.Fatalf("failed to listen on unix socket: %v", err)
+	}
+	unixServer := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, "unix "+r.URL.Path)
+	}))
+	unixServer.Listener = unixListener
+	unixServer.Start()
+	t.Cleanup(unixServer.Close)
+
+	// unix domain socket listener with TLS
+	tlsSocketPath := filepath.Join(t.TempDir(), "nginx:tls.sock")
+	tlsUnixListener, err := net.Listen("unix", tlsSocketPath)
+	if err != nil {
+		t.Fatalf("failed to listen on unix socket: %v", err)
+	}
+	tlsUnixServer := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, "tls unix "+r.TLS.ServerName+" "+r.Host+" "+r.URL.RequestURI())
+	}))
+	tlsUnixServer.Listener = tlsUnixListener
+	tlsUnixServer.StartTLS()
+	t.Cleanup(tlsUnixServer.Close)
+
+	targets := []struct {
+		name     string
+		addr     string
+		wantBody string
+		module   config.Module
+	}{
+		{
+			name:     "unix socket",
+			addr:     "unix:" + socketPath + ":/stub_status",
+			module:   config.Module{Timeout: time.Second},
+			wantBody: "unix /stub_status",
+		},
+		{
+			name:     "http unix socket URL",
+			addr:     "http+unix://" + socketPath + ":/stub_status",
+			module:   config.Module{Timeout: time.Second},
+			wantBody: "unix /stub_status",
+		},
+		{
+			name:     "https unix socket URL",
+			addr:     "https+unix://nginx.example.com" + strings.ReplaceAll(tlsSocketPath, ":", "%3A") + ":/api?fields=nginx",
+			module:   config.Module{Timeout: time.Second, TLSConfig: config.TLSConfig{InsecureSkipVerify: true}},
+			wantBody: "tls unix nginx.example.com nginx.example.com /api?fields=nginx",
+		},
+		{
+			name:     "tcp",
+			addr:     tcpServer.URL,
+			module:   config.Module{Timeout: time.Second},
+			wantBody: "tcp",
+		},
+		{
+			name:     "tcp with proxy protocol",
+			addr:     proxyServer.URL,
+			module:   config.Module{Timeout: time.Second, ProxyProtocol: config.ProxyProtocol{Enabled: true}},
+			wantBody: "proxy 127.0.0.1:",
+		},
+	}
+
+	// create all clients before using any of them, so a dialer overwritten
+	// by a later target would break the earlier ones
+	clients := make([]*http.Client, len(targets))
+	endpoints := make([]string, len(targets))
+	for i, target := range targets {
+		clients[i], endpoints[i], err = newHTTPClient(target.addr, target.module)
+		if err != nil {
+			t.Fatalf("newHTTPClient(%q) error = %v", target.addr, err)
+		}
+		t.Cleanup(clients[i].CloseIdleConnections)
+	}
+
+	for i, target := range targets {
+		req, err := http.NewRequestWithContext(context.Background(), http.MethodGet, endpoints[i], nil)
+		if err != nil {
+			t.Fatalf("%s: failed to create request: %v", target.name, err)
+		}
+		resp, err := clients[i].Do(req)
+		if err != nil {
+			t.Fatalf("%s: request failed: %v", target.name, err)
+		}
+		body, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			t.Fatalf("%s: failed to read body: %v", target.name, err)
+		}
+		if resp.StatusCode != http.StatusOK || !strings.HasPrefix(string(body), target.wantBody) {
+			t.Errorf("%s: got %v %q, want %v %q", target.name, resp.StatusCode, body, http.StatusOK, target.wantBody)
+		}
+	}
+}
+
+func TestNewHTTPClientProxyProtocol(t *testing.T) {
+	t.Parallel()
+
+	// HTTPS listener that expects a PROXY protocol header before the TLS handshake and
+	// returns the header it got
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	type headerKey struct{}
+	nginx := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header, _ := r.Context().Value(headerKey{}).(*proxyproto.Header)
+		if header == nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		tlvs := make(map[proxyproto.PP2Type]string)
+		parsedTLVs, _ := header.TLVs()
+		for _, tlv := range parsedTLVs {
+			tlvs[tlv.Type] = string(tlv.Value)
+		}
+		_, _ = fmt.Fprintf(w, "v%d %s authority=%s alpn=%s unique_id=%s", header.Version, header.SourceAddr,
+			tlvs[proxyproto.PP2_TYPE_AUTHORITY], tlvs[proxyproto.PP2_TYPE_ALPN], tlvs[proxyproto.PP2_TYPE_UNIQUE_ID])
+	}))
+	nginx.Listener = &proxyproto.Listener{Listener: listener}
+	nginx.Config.ConnContext = func(ctx context.Context, c net.Conn) context.Context {
+		if tlsConn, ok := c.(*tls.Conn); ok {
+			c = tlsConn.NetConn()
+		}
+		if conn, ok := c.(*proxyproto.Conn); ok {
+			return context.WithValue(ctx, headerKey{}, conn.ProxyHeader())
+		}
+		return ctx
+	}
+	nginx.StartTLS()
+	t.Cleanup(nginx.Close)
+
+	tests := []struct {
+		name          string
+		wantBody      string
+		proxyProtocol config.ProxyProtocol
+	}{
+		{
+			name: "version 2 with source address and TLVs",
+			proxyProtocol: config.ProxyProtocol{
+				Enabled:       true,
+				Version:       2,
+				SourceAddress: "192.0.2.10:4711",
+				TLVs:          config.ProxyProtocolTLVs{Authority: "lb1.example.com", ALPN: "http/1.1", UniqueID: "exporter-1"},
+			},
+			wantBody: "v2 192.0.2.10:4711 authority=lb1.example.com alpn=http/1.1 unique_id=exporter-1",
+		},
+		{
+			name:          "version 1 with source address without port",
+			proxyProtocol: config.ProxyProtocol{Enabled: true, Version: 1, SourceAddress: "192.0.2.10"},
+			wantBody:      "v1 192.0.2.10:",
+		},
+	}
+
+	for _, tt := range tests {
+		httpClient, endpoint, err := newHTTPClient(nginx.URL, config.Module{
+			Timeout:       time.Second,
+			TLSConfig:     config.TLSConfig{InsecureSkipVerify: true},
+			ProxyProtocol: tt.proxyProtocol,
+		})
+		if err != nil {
+			t.Fatalf("%s: newHTTPClient() returned error: %v", tt.name, err)
+		}
+		t.Cleanup(httpClient.CloseIdleConnections)
+
+		req, err := http.NewRequestWithContext(t.Context(), http.MethodGet, endpoint, nil)
+		if err != nil {
+			t.Fatalf("%s: failed to create request: %v", tt.name, err)
+		}
+		resp, err := httpClient.Do(req)
+		if err != nil {
+			t.Fatalf("%s: request failed: %v", tt.name, err)
+		}
+		body, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			t.Fatalf("%s: failed to read body: %v", tt.name, err)
+		}
+		if resp.StatusCode != http.StatusOK || !strings.HasPrefix(string(body), tt.wantBody) {
+			t.Errorf("%s: got %v %q, want %v %q", tt.name, resp.StatusCode, body, http.StatusOK, tt.wantBody)
+		}
+	}
+}
+
+func TestTargetLabels(t *testing.T) {
+	t.Parallel()
+
+	target := config.Target{
+		URI:    "http://lb1:8080/api",
+		Labels: map[string]string{"dc": "eu-west"},
+	}
+
+	tests := []struct {
+		want        map[string]string
+		name        string
+		multiTarget bool
+	}{
+		{
+			name: "single target",
+			want: map[string]string{"dc": "eu-west"},
+		},
+		{
+			name:        "multiple targets",
+			multiTarget: true,
+			want:        map[string]string{"dc": "eu-west", "addr": "http://lb1:8080/api"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			if got := targetLabels(target, tt.multiTarget); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("targetLabels() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
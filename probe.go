@@ -0,0 +1,84 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+
+	"github.com/nginx/nginx-prometheus-exporter/config"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// probeHandler serves the metrics of the NGINX or NGINX Plus instance given by the
+// target query parameter. The instance is scraped with the settings of the module
+// query parameter, or with the settings of the command-line flags if no module is given.
+// A module with credentials only scrapes the hosts allowed by its probe_allowed_hosts, so
+// the credentials are not sent to any target given by the caller.
+type probeHandler struct {
+	logger        *slog.Logger
+	modules       func() map[string]config.Module
+	constLabels   map[string]string
+	defaultModule config.Module
+}
+
+func (h *probeHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	params := r.URL.Query()
+
+	target := params.Get("target")
+	if target == "" {
+		http.Error(w, "target parameter is missing", http.StatusBadRequest)
+		return
+	}
+
+	module := h.defaultModule
+	if moduleName := params.Get("module"); moduleName != "" {
+		var ok bool
+		module, ok = h.modules()[moduleName]
+		if !ok {
+			http.Error(w, fmt.Sprintf("unknown module %q", moduleName), http.StatusBadRequest)
+			return
+		}
+	}
+
+	if module.HasCredentials() && !module.AllowsProbeHost(targetHost(target)) {
+		h.logger.Warn("target is not allowed by probe_allowed_hosts of the module", "target", target)
+		http.Error(w, fmt.Sprintf("target %q is not allowed for a module with credentials", target), http.StatusForbidden)
+		return
+	}
+
+	// a probe scrapes the target once, so there is nothing to poll or serve stale
+	module.PollInterval = 0
+	module.StaleGracePeriod = 0
+
+	httpClient, endpoint, err := newHTTPClient(target, module)
+	if err != nil {
+		h.logger.Error("creating HTTP client failed", "target", target, "error", err.Error())
+		http.Error(w, fmt.Sprintf("failed to create HTTP client for target %q: %v", target, err), http.StatusBadRequest)
+		return
+	}
+	defer httpClient.CloseIdleConnections()
+	httpClient.Transport = newRetryRoundTripper(httpClient.Transport, module.Retry, nil)
+
+	c, err := newCollector(h.logger, httpClient, endpoint, module, h.constLabels)
+	if err != nil {
+		h.logger.Error("creating collector failed", "target", target, "error", err.Error())
+		http.Error(w, fmt.Sprintf("failed to create collector for target %q: %v", target, err), http.StatusBadRequest)
+		return
+	}
+
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(c)
+	promhttp.HandlerFor(registry, promhttp.HandlerOpts{}).ServeHTTP(w, r)
+}
+
+// targetHost returns the host name of the target URI, or an empty string for a unix
+// domain socket or an invalid URI.
+func targetHost(target string) string {
+	u, err := url.Parse(target)
+	if err != nil {
+		return ""
+	}
+	return u.Hostname()
+}
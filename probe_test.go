@@ -0,0 +1,127 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nginx/nginx-prometheus-exporter/config"
+	"github.com/prometheus/common/promslog"
+)
+
+const validStubStatus = "Active connections: 1457 \nserver accepts handled requests\n 6717066 6717066 65844359 \nReading: 1 Writing: 8 Waiting: 1448 \n"
+
+func TestProbeHandler(t *testing.T) {
+	t.Parallel()
+
+	nginx := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Scrape-Token") != "secret" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		_, _ = io.WriteString(w, validStubStatus)
+	}))
+	t.Cleanup(nginx.Close)
+
+	handler := &probeHandler{
+		logger: promslog.NewNopLogger(),
+		modules: func() map[string]config.Module {
+			return map[string]config.Module{
+				"with_header": {
+					Mode:    config.ModeOSS,
+					Timeout: time.Second,
+					Headers: map[string]string{"X-Scrape-Token": "secret"},
+				},
+				"with_authorization": {
+					Mode:              config.ModeOSS,
+					Timeout:           time.Second,
+					Headers:           map[string]string{"X-Scrape-Token": "secret", "Authorization": "Bearer secret"},
+					ProbeAllowedHosts: []string{`127\.0\.0\.\d+`},
+				},
+				"with_authorization_elsewhere": {
+					Mode:              config.ModeOSS,
+					Timeout:           time.Second,
+					Headers:           map[string]string{"X-Scrape-Token": "secret", "authorization": "Bearer secret"},
+					ProbeAllowedHosts: []string{`nginx\.example\.com`},
+				},
+				"with_basic_auth": {
+					Mode:      config.ModeOSS,
+					Timeout:   time.Second,
+					BasicAuth: &config.BasicAuth{Username: "user", Password: "secret"},
+				},
+			}
+		},
+		defaultModule: config.Module{Mode: config.ModeOSS, Timeout: time.Second},
+	}
+
+	tests := []struct {
+		name         string
+		query        url.Values
+		wantContains string
+		wantCode     int
+	}{
+		{
+			name:         "missing target",
+			query:        url.Values{},
+			wantCode:     http.StatusBadRequest,
+			wantContains: "target parameter is missing",
+		},
+		{
+			name:         "unknown module",
+			query:        url.Values{"target": {nginx.URL}, "module": {"unknown"}},
+			wantCode:     http.StatusBadRequest,
+			wantContains: `unknown module "unknown"`,
+		},
+		{
+			name:         "default module without required header",
+			query:        url.Values{"target": {nginx.URL}},
+			wantCode:     http.StatusOK,
+			wantContains: "nginx_up 0",
+		},
+		{
+			name:         "module with header and no allowed hosts",
+			query:        url.Values{"target": {nginx.URL}, "module": {"with_header"}},
+			wantCode:     http.StatusOK,
+			wantContains: "nginx_connections_active 1457",
+		},
+		{
+			name:         "module with authorization header",
+			query:        url.Values{"target": {nginx.URL}, "module": {"with_authorization"}},
+			wantCode:     http.StatusOK,
+			wantContains: "nginx_connections_active 1457",
+		},
+		{
+			name:         "module with authorization header and target not allowed",
+			query:        url.Values{"target": {nginx.URL}, "module": {"with_authorization_elsewhere"}},
+			wantCode:     http.StatusForbidden,
+			wantContains: "is not allowed for a module with credentials",
+		},
+		{
+			name:         "module with basic auth and no allowed hosts",
+			query:        url.Values{"target": {nginx.URL}, "module": {"with_basic_auth"}},
+			wantCode:     http.StatusForbidden,
+			wantContains: "is not allowed for a module with credentials",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			req := httptest.NewRequest(http.MethodGet, "/probe?"+tt.query.Encode(), nil)
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantCode {
+				t.Errorf("ServeHTTP() code = %v, want %v", rec.Code, tt.wantCode)
+			}
+			if body := rec.Body.String(); !strings.Contains(body, tt.wantContains) {
+				t.Errorf("ServeHTTP() body = %q, want it to contain %q", body, tt.wantContains)
+			}
+		})
+	}
+}
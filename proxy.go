@@ -0,0 +1,114 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+
+	"golang.org/x/net/proxy"
+)
+
+// newProxyDialer returns a dialer that connects to addresses through the proxy at
+// proxyURL, either with an HTTP CONNECT tunnel through an HTTP or HTTPS proxy, or
+// through a SOCKS5 proxy. The proxy itself is dialed with forward.
+func newProxyDialer(proxyURL string, forward *net.Dialer) (proxy.ContextDialer, error) {
+	u, err := url.Parse(proxyURL)
+	if err != nil {
+		return nil, fmt.Errorf("parsing proxy URL failed: %w", err)
+	}
+
+	switch u.Scheme {
+	case "http", "https":
+		return &connectDialer{proxyURL: u, forward: forward}, nil
+	case "socks5", "socks5h":
+		d, err := proxy.FromURL(u, forward)
+		if err != nil {
+			return nil, fmt.Errorf("creating SOCKS5 dialer failed: %w", err)
+		}
+		contextDialer, ok := d.(proxy.ContextDialer)
+		if !ok {
+			return nil, errors.New("SOCKS5 dialer does not support contexts")
+		}
+		return contextDialer, nil
+	default:
+		return nil, fmt.Errorf("unsupported proxy scheme %q", u.Scheme)
+	}
+}
+
+// connectDialer opens connections through an HTTP CONNECT tunnel, so the connection is
+// end to end between the exporter and NGINX and any PROXY protocol header or TLS
+// handshake goes to NGINX rather than the proxy.
+type connectDialer struct {
+	proxyURL *url.URL
+	forward  *net.Dialer
+}
+
+func (d *connectDialer) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	proxyAddr := d.proxyURL.Host
+	if d.proxyURL.Port() == "" {
+		port := "80"
+		if d.proxyURL.Scheme == "https" {
+			port = "443"
+		}
+		proxyAddr = net.JoinHostPort(d.proxyURL.Hostname(), port)
+	}
+
+	conn, err := d.forward.DialContext(ctx, network, proxyAddr)
+	if err != nil {
+		return nil, fmt.Errorf("dialing proxy %s: %w", proxyAddr, err)
+	}
+	if d.proxyURL.Scheme == "https" {
+		conn = tls.Client(conn, &tls.Config{ServerName: d.proxyURL.Hostname(), MinVersion: tls.VersionTLS12})
+	}
+
+	if err := d.connect(ctx, conn, addr); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return conn, nil
+}
+
+func (d *connectDialer) connect(ctx context.Context, conn net.Conn, addr string) error {
+	if deadline, ok := ctx.Deadline(); ok {
+		if err := conn.SetDeadline(deadline); err != nil {
+			return fmt.Errorf("setting deadline failed: %w", err)
+		}
+		defer func() {
+			_ = conn.SetDeadline(time.Time{})
+		}()
+	}
+
+	req := &http.Request{
+		Method: http.MethodConnect,
+		URL:    &url.URL{Opaque: addr},
+		Host:   addr,
+		Header: make(http.Header),
+	}
+	if user := d.proxyURL.User; user != nil {
+		password, _ := user.Password()
+		credentials := base64.StdEncoding.EncodeToString([]byte(user.Username() + ":" + password))
+		req.Header.Set("Proxy-Authorization", "Basic "+credentials)
+	}
+	if err := req.Write(conn); err != nil {
+		return fmt.Errorf("sending CONNECT request to proxy failed: %w", err)
+	}
+
+	// NGINX does not send anything before the request of the exporter, so the reader
+	// buffers nothing after the response of the proxy
+	resp, err := http.ReadResponse(bufio.NewReader(conn), req)
+	if err != nil {
+		return fmt.Errorf("reading CONNECT response of proxy failed: %w", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("proxy refused to connect to %s: %s", addr, resp.Status)
+	}
+	return nil
+}
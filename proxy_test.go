@@ -0,0 +1,181 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/nginx/nginx-prometheus-exporter/config"
+	proxyproto "github.com/pires/go-proxyproto"
+)
+
+// serveProxy accepts connections on a new listener, gets the address to connect to from
+// each connection with handshake and relays the connection to that address. It returns
+// the address of the listener.
+func serveProxy(t *testing.T, handshake func(conn net.Conn, br *bufio.Reader) (string, bool)) string {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				br := bufio.NewReader(conn)
+				addr, ok := handshake(conn, br)
+				if !ok {
+					return
+				}
+				upstream, err := net.Dial("tcp", addr)
+				if err != nil {
+					return
+				}
+				defer upstream.Close()
+				go func() {
+					_, _ = io.Copy(upstream, br)
+				}()
+				_, _ = io.Copy(conn, upstream)
+			}()
+		}
+	}()
+
+	return listener.Addr().String()
+}
+
+func TestNewHTTPClientProxy(t *testing.T) {
+	t.Parallel()
+
+	// NGINX listener that expects a PROXY protocol header
+	nginxListener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	nginx := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, "nginx "+r.RemoteAddr)
+	}))
+	nginx.Listener = &proxyproto.Listener{Listener: nginxListener}
+	nginx.Start()
+	t.Cleanup(nginx.Close)
+
+	var tunnels atomic.Int64
+	httpProxy := serveProxy(t, func(conn net.Conn, br *bufio.Reader) (string, bool) {
+		req, err := http.ReadRequest(br)
+		if err != nil || req.Method != http.MethodConnect || req.Header.Get("Proxy-Authorization") != "Basic dXNlcjpwYXNz" {
+			_, _ = io.WriteString(conn, "HTTP/1.1 407 Proxy Authentication Required\r\n\r\n")
+			return "", false
+		}
+		tunnels.Add(1)
+		_, _ = io.WriteString(conn, "HTTP/1.1 200 Connection established\r\n\r\n")
+		return req.Host, true
+	})
+
+	socksProxy := serveProxy(t, func(conn net.Conn, br *bufio.Reader) (string, bool) {
+		// greeting: version, number of methods and methods, answered with no authentication
+		greeting := make([]byte, 2)
+		if _, err := io.ReadFull(br, greeting); err != nil {
+			return "", false
+		}
+		if _, err := io.ReadFull(br, make([]byte, greeting[1])); err != nil {
+			return "", false
+		}
+		_, _ = conn.Write([]byte{5, 0})
+
+		// request: version, CONNECT, reserved and an IPv4 address or a domain name with the port
+		request := make([]byte, 4)
+		if _, err := io.ReadFull(br, request); err != nil {
+			return "", false
+		}
+		var host string
+		switch request[3] {
+		case 1:
+			ip := make([]byte, 4)
+			if _, err := io.ReadFull(br, ip); err != nil {
+				return "", false
+			}
+			host = net.IP(ip).String()
+		case 3:
+			length, err := br.ReadByte()
+			if err != nil {
+				return "", false
+			}
+			name := make([]byte, length)
+			if _, err := io.ReadFull(br, name); err != nil {
+				return "", false
+			}
+			host = string(name)
+		default:
+			return "", false
+		}
+		port := make([]byte, 2)
+		if _, err := io.ReadFull(br, port); err != nil {
+			return "", false
+		}
+		tunnels.Add(1)
+		_, _ = conn.Write([]byte{5, 0, 0, 1, 0, 0, 0, 0, 0, 0})
+		return net.JoinHostPort(host, strconv.Itoa(int(binary.BigEndian.Uint16(port)))), true
+	})
+
+	tests := []struct {
+		name     string
+		proxyURL string
+	}{
+		{name: "http proxy", proxyURL: "http://user:pass@" + httpProxy},
+		{name: "socks5 proxy", proxyURL: "socks5://" + socksProxy},
+	}
+
+	for _, tt := range tests {
+		httpClient, endpoint, err := newHTTPClient(nginx.URL, config.Module{
+			Timeout:       time.Second,
+			ProxyURL:      tt.proxyURL,
+			ProxyProtocol: config.ProxyProtocol{Enabled: true},
+		})
+		if err != nil {
+			t.Fatalf("%s: newHTTPClient() returned error: %v", tt.name, err)
+		}
+		t.Cleanup(httpClient.CloseIdleConnections)
+
+		before := tunnels.Load()
+		req, err := http.NewRequestWithContext(context.Background(), http.MethodGet, endpoint, nil)
+		if err != nil {
+			t.Fatalf("%s: failed to create request: %v", tt.name, err)
+		}
+		resp, err := httpClient.Do(req)
+		if err != nil {
+			t.Fatalf("%s: request failed: %v", tt.name, err)
+		}
+		body, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			t.Fatalf("%s: failed to read body: %v", tt.name, err)
+		}
+
+		// NGINX gets the PROXY protocol header through the tunnel
+		if !strings.HasPrefix(string(body), "nginx 127.0.0.1:") {
+			t.Errorf("%s: got %q, want the address from the PROXY protocol header", tt.name, body)
+		}
+		if tunnels.Load() != before+1 {
+			t.Errorf("%s: request did not go through the proxy", tt.name)
+		}
+	}
+
+	if _, _, err := newHTTPClient("unix:/var/run/nginx.sock:/stub_status", config.Module{ProxyURL: "http://" + httpProxy}); err == nil {
+		t.Error("newHTTPClient() of a unix domain socket with a proxy returned no error")
+	}
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"sync"
+	"sync/atomic"
+
+	"github.com/nginx/nginx-prometheus-exporter/config"
+	"github.com/nginx/nginx-prometheus-exporter/discovery"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// staticSource is the source of the targets of the configuration file and the command-line flags.
+const staticSource = "static"
+
+// configReloader loads the configuration and applies it to the running exporter.
+type configReloader struct {
+	logger                     *slog.Logger
+	manager                    *targetManager
+	lastReloadSuccessful       prometheus.Gauge
+	lastReloadSuccessTimestamp prometheus.Gauge
+	loadConfig                 func() (*config.Config, error)
+	stopDiscovery              context.CancelFunc
+	probeModules               atomic.Pointer[map[string]config.Module]
+	discoverySources           []string
+	mutex                      sync.Mutex
+}
+
+// discoverySource is a running service discovery and the template of its targets.
+type discoverySource struct {
+	discoverer discovery.Discoverer
+	name       string
+	template   config.TargetTemplate
+}
+
+func newConfigReloader(logger *slog.Logger, registerer prometheus.Registerer, loadConfig func() (*config.Config, error)) *configReloader {
+	r := &configReloader{
+		logger:     logger,
+		loadConfig: loadConfig,
+		lastReloadSuccessful: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: exporterName,
+			Name:      "config_last_reload_successful",
+			Help:      "Whether the last configuration reload attempt was successful",
+		}),
+		lastReloadSuccessTimestamp: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: exporterName,
+			Name:      "config_last_reload_success_timestamp_seconds",
+			Help:      "Timestamp of the last successful configuration reload",
+		}),
+	}
+	coordinator := newScrapeCoordinator(logger)
+	r.manager = newTargetManager(logger, coordinator)
+	registerer.MustRegister(coordinator, r.lastReloadSuccessful, r.lastReloadSuccessTimestamp)
+	return r
+}
+
+// reload loads the configuration and syncs the registered collectors with its targets.
+// If the configuration is not valid, the running configuration is kept.
+func (r *configReloader) reload() error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if err := r.apply(); err != nil {
+		r.lastReloadSuccessful.Set(0)
+		return err
+	}
+
+	r.lastReloadSuccessful.Set(1)
+	r.lastReloadSuccessTimestamp.SetToCurrentTime()
+	return nil
+}
+
+func (r *configReloader) apply() error {
+	cfg, err := r.loadConfig()
+	if err != nil {
+		return err
+	}
+
+	targets, err := resolveTargets(cfg)
+	if err != nil {
+		return err
+	}
+
+	sources, err := newDiscoverySources(r.logger, cfg)
+	if err != nil {
+		return err
+	}
+
+	if err := r.manager.sync(context.Background(), staticSource, targets); err != nil {
+		return err
+	}
+
+	r.probeModules.Store(&cfg.Modules)
+	r.restartDiscovery(sources)
+	return nil
+}
+
+// restartDiscovery stops the running service discovery and starts sources. The targets
+// of a restarted source are kept until it sends its first update, so unchanged targets
+// keep their collectors.
+func (r *configReloader) restartDiscovery(sources []discoverySource) {
+	if r.stopDiscovery != nil {
+		r.stopDiscovery()
+	}
+
+	names := make(map[string]bool, len(sources))
+	for _, source := range sources {
+		names[source.name] = true
+	}
+	for _, name := range r.discoverySources {
+		if !names[name] {
+			if err := r.manager.sync(context.Background(), name, nil); err != nil {
+				r.logger.Error("removing discovered targets failed", "source", name, "error", err.Error())
+			}
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	r.stopDiscovery = cancel
+	r.discoverySources = r.discoverySources[:0]
+	for _, source := range sources {
+		r.discoverySources = append(r.discoverySources, source.name)
+		go r.runDiscovery(ctx, source)
+	}
+}
+
+func (r *configReloader) runDiscovery(ctx context.Context, source discoverySource) {
+	ch := make(chan []discovery.Target)
+	go source.discoverer.Run(ctx, ch)
+
+	for {
+		select {
+		case found := <-ch:
+			targets := discoveredTargets(r.logger, source.template, found)
+			if err := r.manager.sync(ctx, source.name, targets); err != nil {
+				r.logger.Error("updating discovered targets failed", "source", source.name, "error", err.Error())
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func newDiscoverySources(logger *slog.Logger, cfg *config.Config) ([]discoverySource, error) {
+	sources := make([]discoverySource, 0, len(cfg.FileSDConfigs)+len(cfg.DNSSDConfigs)+len(cfg.KubernetesSDConfigs))
+	for i, sd := range cfg.FileSDConfigs {
+		sources = append(sources, discoverySource{
+			name:       fmt.Sprintf("file_sd_configs/%d", i),
+			discoverer: discovery.NewFileDiscoverer(logger, sd.Files, sd.RefreshInterval),
+			template:   sd.TargetTemplate,
+		})
+	}
+
+	for i, sd := range cfg.DNSSDConfigs {
+		sources = append(sources, discoverySource{
+			name:       fmt.Sprintf("dns_sd_configs/%d", i),
+			discoverer: discovery.NewDNSDiscoverer(logger, net.DefaultResolver, sd),
+			template:   sd.TargetTemplate,
+		})
+	}
+
+	for i, sd := range cfg.KubernetesSDConfigs {
+		tlsConfig := sd.APIServerTLSConfig
+		if sd.APIServer == "" && tlsConfig.CAFile == "" {
+			tlsConfig.CAFile = discovery.InClusterCAFile
+		}
+		sslConfig, err := newTLSConfig(tlsConfig)
+		if err != nil {
+			return nil, fmt.Errorf("kubernetes_sd_configs %d: %w", i, err)
+		}
+
+		httpClient := &http.Client{Transport: &http.Transport{TLSClientConfig: sslConfig}}
+		d, err := discovery.NewKubernetesDiscoverer(logger, httpClient, sd)
+		if err != nil {
+			return nil, fmt.Errorf("kubernetes_sd_configs %d: %w", i, err)
+		}
+		sources = append(sources, discoverySource{
+			name:       fmt.Sprintf("kubernetes_sd_configs/%d", i),
+			discoverer: d,
+			template:   sd.TargetTemplate,
+		})
+	}
+
+	return sources, nil
+}
+
+// modules returns the modules of the running configuration.
+func (r *configReloader) modules() map[string]config.Module {
+	if modules := r.probeModules.Load(); modules != nil {
+		return *modules
+	}
+	return nil
+}
+
+// ServeHTTP reloads the configuration on POST requests.
+func (r *configReloader) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "only POST requests are allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if err := r.reload(); err != nil {
+		r.logger.Error("reloading config failed", "error", err.Error())
+		http.Error(w, fmt.Sprintf("failed to reload config: %v", err), http.StatusInternalServerError)
+		return
+	}
+	r.logger.Info("reloaded config")
+}
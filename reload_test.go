@@ -0,0 +1,79 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/nginx/nginx-prometheus-exporter/config"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/prometheus/common/promslog"
+)
+
+func TestConfigReloader(t *testing.T) {
+	t.Parallel()
+
+	nginx := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = io.WriteString(w, validStubStatus)
+	}))
+	t.Cleanup(nginx.Close)
+
+	good := &config.Config{
+		Modules: map[string]config.Module{"default": {Mode: config.ModeOSS, Timeout: time.Second}},
+		Targets: []config.Target{{
+			Name:   "lb1",
+			URI:    nginx.URL,
+			Module: config.Module{Mode: config.ModeOSS, Timeout: time.Second},
+		}},
+	}
+	var loadErr error
+	loadConfig := func() (*config.Config, error) {
+		return good, loadErr
+	}
+
+	registry := prometheus.NewRegistry()
+	reloader := newConfigReloader(promslog.NewNopLogger(), registry, loadConfig)
+
+	post := func() int {
+		rec := httptest.NewRecorder()
+		reloader.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/-/reload", nil))
+		return rec.Code
+	}
+
+	if code := post(); code != http.StatusOK {
+		t.Fatalf("POST /-/reload code = %v, want %v", code, http.StatusOK)
+	}
+	if got := testutil.ToFloat64(reloader.lastReloadSuccessful); got != 1 {
+		t.Errorf("config_last_reload_successful = %v, want 1", got)
+	}
+	if got := testutil.ToFloat64(reloader.lastReloadSuccessTimestamp); got == 0 {
+		t.Error("config_last_reload_success_timestamp_seconds was not set")
+	}
+	if _, ok := reloader.modules()["default"]; !ok {
+		t.Error("modules() does not contain the loaded module")
+	}
+
+	loadErr = errors.New("invalid config")
+	if code := post(); code != http.StatusInternalServerError {
+		t.Errorf("POST /-/reload with a bad config code = %v, want %v", code, http.StatusInternalServerError)
+	}
+	if got := testutil.ToFloat64(reloader.lastReloadSuccessful); got != 0 {
+		t.Errorf("config_last_reload_successful = %v, want 0", got)
+	}
+	if _, ok := reloader.modules()["default"]; !ok {
+		t.Error("a failed reload dropped the running modules")
+	}
+	if len(reloader.manager.targets) != 1 {
+		t.Errorf("a failed reload left %d targets, want 1", len(reloader.manager.targets))
+	}
+
+	rec := httptest.NewRecorder()
+	reloader.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/-/reload", nil))
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("GET /-/reload code = %v, want %v", rec.Code, http.StatusMethodNotAllowed)
+	}
+}
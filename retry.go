@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"syscall"
+	"time"
+
+	"github.com/nginx/nginx-prometheus-exporter/collector"
+	"github.com/nginx/nginx-prometheus-exporter/config"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// retryRoundTripper retries the idempotent requests to NGINX that fail with a network
+// error or a 5xx response, so a single dropped connection does not fail a scrape. It
+// waits between the attempts with an exponential backoff and makes no attempt that
+// would start after the deadline of the request, so retries never extend a scrape.
+type retryRoundTripper struct {
+	rt      http.RoundTripper
+	retries prometheus.Counter
+	retry   config.Retry
+}
+
+// newRetryRoundTripper wraps rt to retry requests as configured by retry, counting the
+// retries with retries if it is not nil. It returns rt if retries are disabled.
+func newRetryRoundTripper(rt http.RoundTripper, retry config.Retry, retries prometheus.Counter) http.RoundTripper {
+	if retry.Attempts <= 1 {
+		return rt
+	}
+	return &retryRoundTripper{rt: rt, retries: retries, retry: retry}
+}
+
+func (rt *retryRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Method != http.MethodGet && req.Method != http.MethodHead {
+		return rt.rt.RoundTrip(req) //nolint:wrapcheck // the error of the wrapped round tripper is returned as is
+	}
+
+	ctx := req.Context()
+	backoff := rt.retry.InitialBackoff
+	for attempt := 1; ; attempt++ {
+		resp, err := rt.rt.RoundTrip(req)
+		if attempt >= rt.retry.Attempts || !retryable(resp, err) || !beforeDeadline(ctx, backoff) {
+			return resp, err //nolint:wrapcheck // the error of the wrapped round tripper is returned as is
+		}
+		if resp != nil {
+			_, _ = io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+		}
+
+		timer := time.NewTimer(backoff)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, fmt.Errorf("waiting to retry request failed: %w", ctx.Err())
+		case <-timer.C:
+		}
+
+		if rt.retries != nil {
+			rt.retries.Inc()
+		}
+		backoff = min(2*backoff, rt.retry.MaxBackoff)
+	}
+}
+
+// CloseIdleConnections closes the idle connections of the wrapped transport.
+func (rt *retryRoundTripper) CloseIdleConnections() {
+	if closer, ok := rt.rt.(interface{ CloseIdleConnections() }); ok {
+		closer.CloseIdleConnections()
+	}
+}
+
+// retryable returns true if a request that returned resp and err may succeed when it
+// is sent again: it failed to connect, the connection was reset or closed before the
+// response was complete, or NGINX returned a 5xx response. Timeouts, TLS and
+// authentication errors and all other errors are not retried.
+func retryable(resp *http.Response, err error) bool {
+	if err == nil {
+		return resp.StatusCode >= http.StatusInternalServerError
+	}
+
+	switch collector.ClassifyScrapeError(err) {
+	case collector.ScrapeErrorDial:
+		return true
+	case collector.ScrapeErrorUnknown:
+		var opErr *net.OpError
+		return errors.Is(err, syscall.ECONNRESET) || errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) ||
+			errors.As(err, &opErr) && (opErr.Op == "read" || opErr.Op == "write")
+	default:
+		return false
+	}
+}
+
+// beforeDeadline returns true if ctx is not done and will not be done after waiting for
+// backoff.
+func beforeDeadline(ctx context.Context, backoff time.Duration) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+	deadline, ok := ctx.Deadline()
+	return !ok || time.Until(deadline) > backoff
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/nginx/nginx-prometheus-exporter/client"
+	"github.com/nginx/nginx-prometheus-exporter/config"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/prometheus/common/promslog"
+)
+
+func TestScrapeTargetRetries(t *testing.T) {
+	t.Parallel()
+
+	// flaky drops the connection of the first request and fails the second one
+	var flakyRequests atomic.Int64
+	flaky := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		switch flakyRequests.Add(1) {
+		case 1:
+			conn, _, err := http.NewResponseController(w).Hijack()
+			if err == nil {
+				conn.Close()
+			}
+		case 2:
+			w.WriteHeader(http.StatusServiceUnavailable)
+		default:
+			_, _ = io.WriteString(w, validStubStatus)
+		}
+	}))
+	t.Cleanup(flaky.Close)
+
+	// down always fails, so it is retried until the next attempt would start after the
+	// scrape timeout
+	var downRequests atomic.Int64
+	down := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		downRequests.Add(1)
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	t.Cleanup(down.Close)
+
+	// broken returns a client error, which is not retried
+	var brokenRequests atomic.Int64
+	broken := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		brokenRequests.Add(1)
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	t.Cleanup(broken.Close)
+
+	logger := promslog.NewNopLogger()
+	coordinator := newScrapeCoordinator(logger)
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(coordinator)
+
+	targets := []struct {
+		name  string
+		uri   string
+		retry config.Retry
+	}{
+		{name: "flaky", uri: flaky.URL, retry: config.Retry{Attempts: 3, InitialBackoff: 10 * time.Millisecond, MaxBackoff: 20 * time.Millisecond}},
+		{name: "down", uri: down.URL, retry: config.Retry{Attempts: 10, InitialBackoff: 200 * time.Millisecond, MaxBackoff: time.Second}},
+		{name: "broken", uri: broken.URL, retry: config.Retry{Attempts: 3, InitialBackoff: 10 * time.Millisecond, MaxBackoff: 20 * time.Millisecond}},
+	}
+	for _, target := range targets {
+		st, err := newScrapeTarget(logger, config.Target{
+			Name:   target.name,
+			URI:    target.uri,
+			Labels: map[string]string{"instance_name": target.name},
+			Module: config.Module{Mode: config.ModeOSS, Timeout: 500 * time.Millisecond, Retry: target.retry},
+		}, coordinator)
+		if err != nil {
+			t.Fatalf("newScrapeTarget() returned error: %v", err)
+		}
+		t.Cleanup(st.close)
+		if err := coordinator.add(st); err != nil {
+			t.Fatalf("add() returned error: %v", err)
+		}
+	}
+
+	expected := `
+# HELP nginx_exporter_scrape_retries_total Total number of retried requests to the target
+# TYPE nginx_exporter_scrape_retries_total counter
+nginx_exporter_scrape_retries_total{target="broken"} 0
+nginx_exporter_scrape_retries_total{target="down"} 1
+nginx_exporter_scrape_retries_total{target="flaky"} 2
+# HELP nginx_exporter_scrape_success Whether the last scrape of the target was successful
+# TYPE nginx_exporter_scrape_success gauge
+nginx_exporter_scrape_success{target="broken"} 0
+nginx_exporter_scrape_success{target="down"} 0
+nginx_exporter_scrape_success{target="flaky"} 1
+`
+	if err := testutil.GatherAndCompare(registry, strings.NewReader(expected), "nginx_exporter_scrape_retries_total", "nginx_exporter_scrape_success"); err != nil {
+		t.Error(err)
+	}
+
+	// the attempts of down start at 0 and 200ms, a third one would start at 600ms
+	if got := downRequests.Load(); got != 2 {
+		t.Errorf("requests to the target that is down = %v, want 2", got)
+	}
+	if got := brokenRequests.Load(); got != 1 {
+		t.Errorf("requests to the target with a client error = %v, want 1", got)
+	}
+}
+
+// failingRoundTripper fails every request with err.
+type failingRoundTripper struct {
+	err      error
+	attempts int
+}
+
+func (rt *failingRoundTripper) RoundTrip(*http.Request) (*http.Response, error) {
+	rt.attempts++
+	return nil, rt.err
+}
+
+func TestRetryRoundTripperErrors(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		err          error
+		name         string
+		wantAttempts int
+	}{
+		{name: "dial error", err: &net.OpError{Op: "dial", Net: "tcp", Err: syscall.ECONNREFUSED}, wantAttempts: 3},
+		{name: "connection reset", err: &net.OpError{Op: "read", Net: "tcp", Err: syscall.ECONNRESET}, wantAttempts: 3},
+		{name: "connection closed", err: fmt.Errorf("reading response: %w", io.EOF), wantAttempts: 3},
+		{name: "truncated response", err: fmt.Errorf("reading body: %w", io.ErrUnexpectedEOF), wantAttempts: 3},
+		{name: "parse error", err: &client.ParseError{Body: "<html></html>", Err: errors.New("invalid input")}, wantAttempts: 1},
+		{name: "other error", err: errors.New("unsupported protocol scheme"), wantAttempts: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			failing := &failingRoundTripper{err: tt.err}
+			rt := newRetryRoundTripper(failing, config.Retry{Attempts: 3, InitialBackoff: time.Millisecond, MaxBackoff: time.Millisecond}, nil)
+			req := httptest.NewRequestWithContext(t.Context(), http.MethodGet, "http://nginx/stub_status", nil)
+			if _, err := rt.RoundTrip(req); !errors.Is(err, tt.err) {
+				t.Errorf("RoundTrip() error = %v, want %v", err, tt.err)
+			}
+			if failing.attempts != tt.wantAttempts {
+				t.Errorf("RoundTrip() made %v attempts, want %v", failing.attempts, tt.wantAttempts)
+			}
+		})
+	}
+}
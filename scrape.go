@@ -0,0 +1,324 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nginx/nginx-prometheus-exporter/collector"
+	"github.com/nginx/nginx-prometheus-exporter/config"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// scrapeCoordinator collects the metrics of all scrape targets. It scrapes the targets
+// in parallel, each with a deadline of its timeout, so a slow target does not delay the
+// metrics of the others beyond its own timeout. Along with the metrics of the targets,
+// it exports metrics about the scrapes of every target and the requests they make, so
+// a broken connection between the exporter and NGINX can be told apart from NGINX being
+// down. It implements prometheus.Collector.
+type scrapeCoordinator struct {
+	logger          *slog.Logger
+	durationDesc    *prometheus.Desc
+	successDesc     *prometheus.Desc
+	certExpiryDesc  *prometheus.Desc
+	errorsDesc      *prometheus.Desc
+	retries         *prometheus.CounterVec
+	lastSuccess     *prometheus.GaugeVec
+	requestDuration *prometheus.HistogramVec
+	responseSize    *prometheus.HistogramVec
+	targets         map[*scrapeTarget]bool
+	names           map[string]*scrapeTarget
+	descOwners      map[string]*scrapeTarget
+	mutex           sync.RWMutex
+}
+
+func newScrapeCoordinator(logger *slog.Logger) *scrapeCoordinator {
+	return &scrapeCoordinator{
+		logger: logger,
+		durationDesc: prometheus.NewDesc(
+			prometheus.BuildFQName(exporterName, "", "scrape_duration_seconds"),
+			"Duration of the last scrape of the target",
+			[]string{"target"}, nil,
+		),
+		successDesc: prometheus.NewDesc(
+			prometheus.BuildFQName(exporterName, "", "scrape_success"),
+			"Whether the last scrape of the target was successful",
+			[]string{"target"}, nil,
+		),
+		certExpiryDesc: prometheus.NewDesc(
+			prometheus.BuildFQName(exporterName, "", "tls_client_certificate_expiry_timestamp_seconds"),
+			"Expiry time of the client certificate loaded for the target (expressed as Unix Epoch Time)",
+			[]string{"target"}, nil,
+		),
+		errorsDesc: prometheus.NewDesc(
+			prometheus.BuildFQName(exporterName, "", "scrape_errors_total"),
+			"Total number of failed scrapes of the target by error class",
+			[]string{"target", "class"}, nil,
+		),
+		retries: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: exporterName,
+			Name:      "scrape_retries_total",
+			Help:      "Total number of retried requests to the target",
+		}, []string{"target"}),
+		lastSuccess: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: exporterName,
+			Name:      "last_successful_scrape_timestamp_seconds",
+			Help:      "Time of the last successful scrape of the target (expressed as Unix Epoch Time)",
+		}, []string{"target"}),
+		requestDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: exporterName,
+			Name:      "http_request_duration_seconds",
+			Help:      "Duration of the requests to the endpoints of the target until the response headers are received",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"target", "endpoint"}),
+		responseSize: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: exporterName,
+			Name:      "http_response_size_bytes",
+			Help:      "Size of the response bodies of the endpoints of the target",
+			Buckets:   prometheus.ExponentialBuckets(256, 4, 8),
+		}, []string{"target", "endpoint"}),
+		targets:    make(map[*scrapeTarget]bool),
+		names:      make(map[string]*scrapeTarget),
+		descOwners: make(map[string]*scrapeTarget),
+	}
+}
+
+// add adds st to the scraped targets. Like prometheus.Registerer.Register, it returns an
+// error if the collector of st describes metrics already described by another target.
+func (c *scrapeCoordinator) add(st *scrapeTarget) error {
+	descs := describe(st.collector)
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if other, ok := c.names[st.target.Name]; ok && other != st {
+		return fmt.Errorf("a target with the name %q is already scraped", st.target.Name)
+	}
+	for _, desc := range descs {
+		if other, ok := c.descOwners[desc]; ok && other != st {
+			return fmt.Errorf("target %q has the same metrics and labels as target %q", st.target.Name, other.target.Name)
+		}
+	}
+
+	c.targets[st] = true
+	c.names[st.target.Name] = st
+	for _, desc := range descs {
+		c.descOwners[desc] = st
+	}
+	return nil
+}
+
+// remove removes st from the scraped targets.
+func (c *scrapeCoordinator) remove(st *scrapeTarget) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	delete(c.targets, st)
+	if c.names[st.target.Name] == st {
+		delete(c.names, st.target.Name)
+		labels := prometheus.Labels{"target": st.target.Name}
+		c.retries.DeletePartialMatch(labels)
+		c.lastSuccess.DeletePartialMatch(labels)
+		c.requestDuration.DeletePartialMatch(labels)
+		c.responseSize.DeletePartialMatch(labels)
+	}
+	for desc, owner := range c.descOwners {
+		if owner == st {
+			delete(c.descOwners, desc)
+		}
+	}
+}
+
+// Describe sends the descriptors of the metrics about the scrapes. The metrics of the
+// targets change with the targets, so they are not described.
+func (c *scrapeCoordinator) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.durationDesc
+	ch <- c.successDesc
+	ch <- c.certExpiryDesc
+	ch <- c.errorsDesc
+	c.retries.Describe(ch)
+	c.lastSuccess.Describe(ch)
+	c.requestDuration.Describe(ch)
+	c.responseSize.Describe(ch)
+}
+
+// Collect scrapes all targets in parallel and sends their metrics to the provided channel.
+func (c *scrapeCoordinator) Collect(ch chan<- prometheus.Metric) {
+	c.mutex.RLock()
+	targets := make([]*scrapeTarget, 0, len(c.targets))
+	for st := range c.targets {
+		targets = append(targets, st)
+	}
+	c.mutex.RUnlock()
+
+	var wg sync.WaitGroup
+	for _, st := range targets {
+		wg.Go(func() {
+			c.scrape(st, ch)
+		})
+	}
+	wg.Wait()
+
+	c.retries.Collect(ch)
+	c.lastSuccess.Collect(ch)
+	c.requestDuration.Collect(ch)
+	c.responseSize.Collect(ch)
+}
+
+func (c *scrapeCoordinator) scrape(st *scrapeTarget, ch chan<- prometheus.Metric) {
+	ctx := context.Background()
+	if st.target.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, st.target.Timeout)
+		defer cancel()
+	}
+
+	start := time.Now()
+	err := st.collector.Scrape(ctx, ch)
+	duration := time.Since(start)
+
+	success := 1.0
+	if err != nil {
+		success = 0
+		c.logger.Error("scraping target failed", "target", st.target.Name, "class", collector.ClassifyScrapeError(err), "duration", duration, "error", err.Error())
+	} else {
+		c.lastSuccess.WithLabelValues(st.target.Name).SetToCurrentTime()
+	}
+
+	ch <- prometheus.MustNewConstMetric(c.durationDesc, prometheus.GaugeValue, duration.Seconds(), st.target.Name)
+	ch <- prometheus.MustNewConstMetric(c.successDesc, prometheus.GaugeValue, success, st.target.Name)
+	// the errors are counted by the collector when it fetches the stats, so a failed poll
+	// is counted once, no matter how often it is scraped
+	for class, count := range st.collector.ScrapeErrors() {
+		ch <- prometheus.MustNewConstMetric(c.errorsDesc, prometheus.CounterValue, count, st.target.Name, class)
+	}
+	if expiry := st.tlsFiles.clientCertificateExpiry(); !expiry.IsZero() {
+		ch <- prometheus.MustNewConstMetric(c.certExpiryDesc, prometheus.GaugeValue, float64(expiry.Unix()), st.target.Name)
+	}
+}
+
+// instrument returns a round tripper that observes the duration and response size of
+// every attempt of the requests of the target with the given name, made through rt, and
+// counts the retries of the requests as configured by retry.
+func (c *scrapeCoordinator) instrument(name string, rt http.RoundTripper, retry config.Retry) http.RoundTripper {
+	labels := prometheus.Labels{"target": name}
+	instrumented := &instrumentedRoundTripper{
+		rt:       rt,
+		duration: c.requestDuration.MustCurryWith(labels),
+		size:     c.responseSize.MustCurryWith(labels),
+	}
+	return newRetryRoundTripper(instrumented, retry, c.retries.With(labels))
+}
+
+// instrumentedRoundTripper observes the duration and response size of requests by the
+// requested endpoint, as returned by apiEndpoint.
+type instrumentedRoundTripper struct {
+	rt       http.RoundTripper
+	duration prometheus.ObserverVec
+	size     prometheus.ObserverVec
+}
+
+func (rt *instrumentedRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	start := time.Now()
+	resp, err := rt.rt.RoundTrip(req)
+	if err != nil {
+		return nil, err //nolint:wrapcheck // the error of the wrapped round tripper is returned as is
+	}
+
+	endpoint := apiEndpoint(req.URL.Path)
+	rt.duration.WithLabelValues(endpoint).Observe(time.Since(start).Seconds())
+	resp.Body = &sizeObservingBody{ReadCloser: resp.Body, size: rt.size.WithLabelValues(endpoint)}
+	return resp, nil
+}
+
+// apiEndpoints are the endpoints at the root of a version of the NGINX Plus API.
+var apiEndpoints = map[string]bool{
+	"caches": true, "connections": true, "http": true, "license": true, "nginx": true, "processes": true,
+	"resolvers": true, "slabs": true, "ssl": true, "stream": true, "workers": true,
+}
+
+// apiCollections are the endpoints of the NGINX Plus API whose subpaths are the names of
+// zones, upstreams or servers.
+var apiCollections = map[string]bool{
+	"caches": true, "keyvals": true, "limit_conns": true, "limit_reqs": true, "location_zones": true,
+	"resolvers": true, "server_zones": true, "servers": true, "slabs": true, "upstreams": true, "workers": true,
+}
+
+// apiEndpoint returns the endpoint of the request for path, so the number of endpoints of
+// a target is bounded. For a path of the NGINX Plus API, it is the path below the API
+// version, with the names of zones, upstreams and servers replaced by {name}, such as
+// /http/keyvals/{name} for /api/9/http/keyvals/sessions. Other paths, such as the path of
+// the stub status page, are returned as is.
+func apiEndpoint(path string) string {
+	segments := strings.Split(strings.Trim(path, "/"), "/")
+	for i := 0; i+1 < len(segments); i++ {
+		if !isAPIVersion(segments[i]) || !apiEndpoints[segments[i+1]] {
+			continue
+		}
+		endpoint := segments[i+1:]
+		for j := 1; j < len(endpoint); j++ {
+			if apiCollections[endpoint[j-1]] && endpoint[j] != "" {
+				endpoint[j] = "{name}"
+			}
+		}
+		return "/" + strings.Join(endpoint, "/")
+	}
+
+	if path == "" {
+		return "/"
+	}
+	return path
+}
+
+func isAPIVersion(segment string) bool {
+	return segment != "" && strings.Trim(segment, "0123456789") == ""
+}
+
+// CloseIdleConnections closes the idle connections of the wrapped transport.
+func (rt *instrumentedRoundTripper) CloseIdleConnections() {
+	if closer, ok := rt.rt.(interface{ CloseIdleConnections() }); ok {
+		closer.CloseIdleConnections()
+	}
+}
+
+// sizeObservingBody observes the number of bytes read from a response body when it is
+// closed.
+type sizeObservingBody struct {
+	io.ReadCloser
+	size prometheus.Observer
+	read int
+	once sync.Once
+}
+
+func (b *sizeObservingBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.read += n
+	return n, err //nolint:wrapcheck // io.EOF must not be wrapped
+}
+
+func (b *sizeObservingBody) Close() error {
+	b.once.Do(func() {
+		b.size.Observe(float64(b.read))
+	})
+	return b.ReadCloser.Close() //nolint:wrapcheck // the error of the wrapped body is returned as is
+}
+
+// describe returns the descriptors of the metrics of c as strings.
+func describe(c prometheus.Collector) []string {
+	ch := make(chan *prometheus.Desc)
+	go func() {
+		c.Describe(ch)
+		close(ch)
+	}()
+
+	var descs []string
+	for desc := range ch {
+		descs = append(descs, desc.String())
+	}
+	return descs
+}
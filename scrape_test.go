@@ -0,0 +1,189 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nginx/nginx-prometheus-exporter/config"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/prometheus/common/promslog"
+)
+
+func TestScrapeCoordinator(t *testing.T) {
+	t.Parallel()
+
+	fast := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = io.WriteString(w, validStubStatus)
+	}))
+	t.Cleanup(fast.Close)
+
+	slow := httptest.NewServer(http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(10 * time.Second):
+		}
+	}))
+	t.Cleanup(slow.Close)
+
+	logger := promslog.NewNopLogger()
+	coordinator := newScrapeCoordinator(logger)
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(coordinator)
+
+	for name, uri := range map[string]string{"fast": fast.URL, "slow": slow.URL} {
+		st, err := newScrapeTarget(logger, config.Target{
+			Name:   name,
+			URI:    uri,
+			Labels: map[string]string{"instance_name": name},
+			// the HTTP client timeout is longer than a scrape, so only the deadline ends the slow scrape
+			Module: config.Module{Mode: config.ModeOSS, Timeout: 200 * time.Millisecond},
+		}, coordinator)
+		if err != nil {
+			t.Fatalf("newScrapeTarget() returned error: %v", err)
+		}
+		st.httpClient.Timeout = time.Minute
+		if err := coordinator.add(st); err != nil {
+			t.Fatalf("add() returned error: %v", err)
+		}
+	}
+
+	start := time.Now()
+	families, err := registry.Gather()
+	if err != nil {
+		t.Fatalf("Gather() returned error: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("Gather() took %v, want it to end with the deadline of the slow target", elapsed)
+	}
+
+	got := make(map[string]float64)
+	for _, family := range families {
+		for _, metric := range family.GetMetric() {
+			for _, label := range metric.GetLabel() {
+				if label.GetName() == "target" || label.GetName() == "instance_name" {
+					got[family.GetName()+"/"+label.GetValue()] = metric.GetGauge().GetValue()
+				}
+			}
+		}
+	}
+
+	want := map[string]float64{
+		"nginx_exporter_scrape_success/fast": 1,
+		"nginx_exporter_scrape_success/slow": 0,
+		"nginx_up/fast":                      1,
+		"nginx_up/slow":                      0,
+	}
+	for key, value := range want {
+		if got[key] != value {
+			t.Errorf("%s = %v, want %v", key, got[key], value)
+		}
+	}
+	if duration := got["nginx_exporter_scrape_duration_seconds/slow"]; duration < 0.2 {
+		t.Errorf("scrape duration of the slow target = %v, want at least its timeout", duration)
+	}
+
+	errorCount, responseSize := 0.0, 0.0
+	for _, family := range families {
+		for _, metric := range family.GetMetric() {
+			labels := make(map[string]string)
+			for _, label := range metric.GetLabel() {
+				labels[label.GetName()] = label.GetValue()
+			}
+			switch family.GetName() {
+			case "nginx_exporter_scrape_errors_total":
+				if labels["target"] == "slow" && labels["class"] == "timeout" {
+					errorCount = metric.GetCounter().GetValue()
+				}
+			case "nginx_exporter_http_response_size_bytes":
+				if labels["target"] == "fast" && labels["endpoint"] == "/" {
+					responseSize = metric.GetHistogram().GetSampleSum()
+				}
+			}
+		}
+	}
+	if errorCount != 1 {
+		t.Errorf("timeout errors of the slow target = %v, want 1", errorCount)
+	}
+	if want := float64(len(validStubStatus)); responseSize != want {
+		t.Errorf("response size of the fast target = %v, want %v", responseSize, want)
+	}
+}
+
+func TestScrapeCoordinatorPollingErrors(t *testing.T) {
+	t.Parallel()
+
+	nginx := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	t.Cleanup(nginx.Close)
+
+	logger := promslog.NewNopLogger()
+	coordinator := newScrapeCoordinator(logger)
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(coordinator)
+
+	st, err := newScrapeTarget(logger, config.Target{
+		Name:   "polled",
+		URI:    nginx.URL,
+		Module: config.Module{Mode: config.ModeOSS, Timeout: time.Second, PollInterval: time.Hour},
+	}, coordinator)
+	if err != nil {
+		t.Fatalf("newScrapeTarget() returned error: %v", err)
+	}
+	t.Cleanup(st.close)
+	if err := coordinator.add(st); err != nil {
+		t.Fatalf("add() returned error: %v", err)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for len(st.collector.ScrapeErrors()) == 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	want := `# HELP nginx_exporter_scrape_errors_total Total number of failed scrapes of the target by error class
+# TYPE nginx_exporter_scrape_errors_total counter
+nginx_exporter_scrape_errors_total{class="http_status",target="polled"} 1
+`
+	// every scrape serves the snapshot of the same failed poll
+	for range 3 {
+		if err := testutil.GatherAndCompare(registry, strings.NewReader(want), "nginx_exporter_scrape_errors_total"); err != nil {
+			t.Error(err)
+		}
+	}
+}
+
+func TestAPIEndpoint(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		path string
+		want string
+	}{
+		{path: "", want: "/"},
+		{path: "/stub_status", want: "/stub_status"},
+		{path: "/status/2", want: "/status/2"},
+		{path: "/api/", want: "/api/"},
+		{path: "/api/9/nginx", want: "/nginx"},
+		{path: "/api/9/", want: "/api/9/"},
+		{path: "/api/9/http/server_zones", want: "/http/server_zones"},
+		{path: "/api/8/http/keyvals/sessions", want: "/http/keyvals/{name}"},
+		{path: "/api/9/stream/keyvals/sessions", want: "/stream/keyvals/{name}"},
+		{path: "/plus/api/9/http/upstreams/backend/servers/3", want: "/http/upstreams/{name}/servers/{name}"},
+		{path: "/api/9/resolvers/resolver1", want: "/resolvers/{name}"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			t.Parallel()
+
+			if got := apiEndpoint(tt.path); got != tt.want {
+				t.Errorf("apiEndpoint(%q) = %q, want %q", tt.path, got, tt.want)
+			}
+		})
+	}
+}
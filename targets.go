@@ -0,0 +1,270 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"maps"
+	"net/http"
+	"reflect"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/nginx/nginx-prometheus-exporter/collector"
+	"github.com/nginx/nginx-prometheus-exporter/config"
+	"github.com/nginx/nginx-prometheus-exporter/discovery"
+	"github.com/prometheus/common/model"
+)
+
+// scrapeTarget is a scrape target together with the collector that scrapes it.
+type scrapeTarget struct {
+	collector  collector.Scraper
+	httpClient *http.Client
+	tlsFiles   *tlsFiles
+	stop       context.CancelFunc
+	target     config.Target
+}
+
+func newScrapeTarget(logger *slog.Logger, target config.Target, coordinator *scrapeCoordinator) (*scrapeTarget, error) {
+	files, err := newTLSFiles(target.TLSConfig)
+	if err != nil {
+		return nil, fmt.Errorf("loading TLS files for target %q failed: %w", target.Name, err)
+	}
+	httpClient, endpoint, err := newHTTPClientWithTLS(target.URI, target.Module, files)
+	if err != nil {
+		return nil, fmt.Errorf("creating HTTP client for target %q failed: %w", target.Name, err)
+	}
+	httpClient.Transport = coordinator.instrument(target.Name, httpClient.Transport, target.Retry)
+
+	c, err := newCollector(logger, httpClient, endpoint, target.Module, target.Labels)
+	if err != nil {
+		httpClient.CloseIdleConnections()
+		return nil, fmt.Errorf("creating collector for target %q failed: %w", target.Name, err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go c.Run(ctx)
+
+	return &scrapeTarget{
+		collector:  c,
+		httpClient: httpClient,
+		tlsFiles:   files,
+		stop:       cancel,
+		target:     target,
+	}, nil
+}
+
+func (t *scrapeTarget) close() {
+	t.stop()
+	t.httpClient.CloseIdleConnections()
+}
+
+// targetManager keeps a collector scraped by the coordinator for every scrape target. The targets come
+// from several sources, such as the configuration file and service discovery.
+type targetManager struct {
+	coordinator *scrapeCoordinator
+	logger      *slog.Logger
+	sources     map[string][]config.Target
+	targets     map[string]*scrapeTarget
+	mutex       sync.Mutex
+}
+
+func newTargetManager(logger *slog.Logger, coordinator *scrapeCoordinator) *targetManager {
+	return &targetManager{
+		coordinator: coordinator,
+		logger:      logger,
+		sources:     make(map[string][]config.Target),
+		targets:     make(map[string]*scrapeTarget),
+	}
+}
+
+// sync replaces the targets of source and makes the registered collectors match the
+// targets of all sources. Collectors of unchanged targets are kept, collectors of removed
+// targets are removed from the coordinator and collectors for new targets are added. A
+// target that has the same name as another target, or whose collector collides with the
+// collector of another target, is logged and skipped, and the other targets are still
+// applied. If any of the new collectors cannot be created, the previous targets of
+// source and their collectors are left in place and an error is returned. Once ctx is
+// canceled, the targets of source are no longer updated.
+func (m *targetManager) sync(ctx context.Context, source string, targets []config.Target) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if ctx.Err() != nil {
+		// the source was stopped by a reload, its targets are outdated
+		return nil
+	}
+
+	previous, ok := m.sources[source]
+	if len(targets) > 0 {
+		m.sources[source] = targets
+	} else {
+		delete(m.sources, source)
+	}
+
+	if err := m.apply(); err != nil {
+		if ok {
+			m.sources[source] = previous
+		} else {
+			delete(m.sources, source)
+		}
+		return err
+	}
+	return nil
+}
+
+// desiredTargets returns the targets of all sources by their names. Of the targets with
+// the same name, only the first one is kept, with the static targets going first and the
+// discovered targets in the order of their sources, so the same target is kept on every
+// update.
+func (m *targetManager) desiredTargets() map[string]config.Target {
+	sources := slices.Sorted(maps.Keys(m.sources))
+	if i := slices.Index(sources, staticSource); i > 0 {
+		sources = slices.Insert(slices.Delete(sources, i, i+1), 0, staticSource)
+	}
+
+	desired := make(map[string]config.Target)
+	names := make(map[string]string)
+	for _, source := range sources {
+		for _, target := range m.sources[source] {
+			kept, ok := desired[target.Name]
+			if !ok {
+				names[target.Name] = source
+				desired[target.Name] = target
+				continue
+			}
+			if !reflect.DeepEqual(kept, target) {
+				m.logger.Warn("skipping scrape target with the same name as another target", "target", target.Name, "source", source, "other_source", names[target.Name])
+			}
+		}
+	}
+	return desired
+}
+
+func (m *targetManager) apply() error {
+	desired := m.desiredTargets()
+
+	// any change to the settings of a target results in a new collector
+	added := make(map[string]*scrapeTarget)
+	for name, target := range desired {
+		if st, ok := m.targets[name]; ok && reflect.DeepEqual(st.target, target) {
+			continue
+		}
+		st, err := newScrapeTarget(m.logger, target, m.coordinator)
+		if err != nil {
+			closeTargets(added)
+			return err
+		}
+		added[name] = st
+	}
+
+	for name, st := range m.targets {
+		if target, ok := desired[name]; !ok || !reflect.DeepEqual(st.target, target) {
+			m.coordinator.remove(st)
+			st.close()
+			delete(m.targets, name)
+			m.logger.Info("removed scrape target", "target", st.target.Name)
+		}
+	}
+
+	for name, st := range added {
+		if err := m.coordinator.add(st); err != nil {
+			m.logger.Error("skipping scrape target", "target", st.target.Name, "error", err.Error())
+			st.close()
+			continue
+		}
+		m.targets[name] = st
+		m.logger.Info("added scrape target", "target", st.target.Name)
+	}
+
+	return nil
+}
+
+func closeTargets(targets map[string]*scrapeTarget) {
+	for _, st := range targets {
+		st.close()
+	}
+}
+
+// resolveTargets returns the targets of cfg, or the targets given by the command-line
+// flags if cfg has neither targets nor service discovery, with the const labels of their
+// collectors.
+func resolveTargets(cfg *config.Config) ([]config.Target, error) {
+	targets := cfg.Targets
+	if len(targets) == 0 && !cfg.HasDiscovery() {
+		if len(*scrapeURIs) == 0 {
+			return nil, errors.New("no scrape addresses provided")
+		}
+		targets = flagTargets()
+	}
+
+	resolved := make([]config.Target, 0, len(targets))
+	for _, target := range targets {
+		target.Labels = targetLabels(target, len(targets) > 1)
+		resolved = append(resolved, target)
+	}
+	return resolved, nil
+}
+
+// discoveredTargets returns the scrape targets for the targets found by service discovery.
+// The scrape URI of a target is made of the scheme, address and path of the template,
+// unless its reserved labels override them. The scrape URI is added as the addr label.
+// Targets that are not valid are logged and skipped.
+func discoveredTargets(logger *slog.Logger, template config.TargetTemplate, found []discovery.Target) []config.Target {
+	targets := make([]config.Target, 0, len(found))
+	for _, f := range found {
+		target, err := discoveredTarget(template, f)
+		if err != nil {
+			logger.Error("skipping discovered target", "address", f.Address, "error", err.Error())
+			continue
+		}
+		targets = append(targets, target)
+	}
+	return targets
+}
+
+func discoveredTarget(template config.TargetTemplate, found discovery.Target) (config.Target, error) {
+	module := template.Module
+	if mode, ok := found.Labels[discovery.ModeLabel]; ok {
+		module.Mode = mode
+	}
+
+	scheme := template.Scheme
+	if s, ok := found.Labels[discovery.SchemeLabel]; ok {
+		scheme = s
+	}
+
+	path := template.Path
+	if p, ok := found.Labels[discovery.MetricsPathLabel]; ok {
+		path = p
+	}
+	if path == "" {
+		path = "/stub_status"
+		if module.Mode == config.ModePlus {
+			path = "/api"
+		}
+	}
+
+	labels := make(map[string]string)
+	for name, value := range found.Labels {
+		if !strings.HasPrefix(name, model.ReservedLabelPrefix) {
+			labels[name] = value
+		}
+	}
+
+	uri := scheme + "://" + found.Address + path
+	target := config.Target{
+		Name:   uri,
+		URI:    uri,
+		Labels: collector.MergeLabels(template.Labels, labels),
+		Module: module,
+	}
+	if err := target.Validate(); err != nil {
+		return config.Target{}, fmt.Errorf("invalid target %q: %w", uri, err)
+	}
+
+	target.Labels = targetLabels(target, true)
+	return target, nil
+}
@@ -0,0 +1,361 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nginx/nginx-prometheus-exporter/config"
+	"github.com/nginx/nginx-prometheus-exporter/discovery"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/promslog"
+)
+
+func TestTargetManagerSync(t *testing.T) {
+	t.Parallel()
+
+	nginx := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = io.WriteString(w, validStubStatus)
+	}))
+	t.Cleanup(nginx.Close)
+
+	newTarget := func(name string) config.Target {
+		return config.Target{
+			Name:   name,
+			URI:    nginx.URL,
+			Labels: map[string]string{"instance_name": name},
+			Module: config.Module{Mode: config.ModeOSS, Timeout: time.Second},
+		}
+	}
+
+	coordinator := newScrapeCoordinator(promslog.NewNopLogger())
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(coordinator)
+	manager := newTargetManager(promslog.NewNopLogger(), coordinator)
+
+	if err := manager.sync(t.Context(), staticSource, []config.Target{newTarget("a"), newTarget("b")}); err != nil {
+		t.Fatalf("sync() returned error: %v", err)
+	}
+	assertInstances(t, registry, []string{"a", "b"})
+	collectorA := collectorFor(t, manager, "a")
+
+	if err := manager.sync(t.Context(), staticSource, []config.Target{newTarget("a"), newTarget("c")}); err != nil {
+		t.Fatalf("sync() returned error: %v", err)
+	}
+	assertInstances(t, registry, []string{"a", "c"})
+	if collectorFor(t, manager, "a") != collectorA {
+		t.Error("sync() replaced the collector of an unchanged target")
+	}
+
+	badTLS := newTarget("d")
+	badTLS.TLSConfig.CAFile = "/nonexistent/ca.pem"
+	if err := manager.sync(t.Context(), staticSource, []config.Target{newTarget("a"), badTLS}); err == nil {
+		t.Error("sync() with an invalid CA file returned no error")
+	}
+	assertInstances(t, registry, []string{"a", "c"})
+
+	// same name as target a, so only the first one is kept and the other targets are
+	// still applied
+	duplicate := newTarget("a")
+	duplicate.Timeout = 2 * time.Second
+	if err := manager.sync(t.Context(), staticSource, []config.Target{newTarget("a"), duplicate, newTarget("c")}); err != nil {
+		t.Errorf("sync() with targets of the same name returned error: %v", err)
+	}
+	assertInstances(t, registry, []string{"a", "c"})
+	if collectorFor(t, manager, "a") != collectorA {
+		t.Error("sync() replaced the collector of an unchanged target")
+	}
+
+	// same labels as target a, so the collectors collide and only the new one is skipped
+	sameLabels := newTarget("e")
+	sameLabels.Labels = map[string]string{"instance_name": "a"}
+	if err := manager.sync(t.Context(), staticSource, []config.Target{newTarget("a"), sameLabels, newTarget("f")}); err != nil {
+		t.Errorf("sync() with colliding collectors returned error: %v", err)
+	}
+	assertInstances(t, registry, []string{"a", "f"})
+	if collectorFor(t, manager, "a") != collectorA {
+		t.Error("sync() replaced the collector of an unchanged target")
+	}
+
+	changed := newTarget("a")
+	changed.Timeout = 2 * time.Second
+	if err := manager.sync(t.Context(), staticSource, []config.Target{changed, newTarget("f")}); err != nil {
+		t.Fatalf("sync() returned error: %v", err)
+	}
+	assertInstances(t, registry, []string{"a", "f"})
+	if collectorFor(t, manager, "a") == collectorA {
+		t.Error("sync() kept the collector of a changed target")
+	}
+}
+
+func TestTargetManagerSyncFileSDDuplicates(t *testing.T) {
+	t.Parallel()
+
+	nginx := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = io.WriteString(w, validStubStatus)
+	}))
+	t.Cleanup(nginx.Close)
+	other := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = io.WriteString(w, validStubStatus)
+	}))
+	t.Cleanup(other.Close)
+
+	logger := promslog.NewNopLogger()
+	coordinator := newScrapeCoordinator(logger)
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(coordinator)
+	manager := newTargetManager(logger, coordinator)
+
+	template := config.TargetTemplate{
+		Scheme: "http",
+		Path:   "/stub_status",
+		Module: config.Module{Mode: config.ModeOSS, Timeout: time.Second},
+	}
+	address := strings.TrimPrefix(nginx.URL, "http://")
+	// the same address is listed twice in the file, with different labels
+	found := []discovery.Target{
+		{Address: address, Labels: map[string]string{"instance_name": "first"}},
+		{Address: address, Labels: map[string]string{"instance_name": "second"}},
+	}
+
+	if err := manager.sync(t.Context(), "file_sd_configs/0", discoveredTargets(logger, template, found)); err != nil {
+		t.Fatalf("sync() returned error: %v", err)
+	}
+	assertInstances(t, registry, []string{"first"})
+
+	// later changes of the file still take effect
+	found = append(found, discovery.Target{Address: strings.TrimPrefix(other.URL, "http://"), Labels: map[string]string{"instance_name": "other"}})
+	if err := manager.sync(t.Context(), "file_sd_configs/0", discoveredTargets(logger, template, found)); err != nil {
+		t.Fatalf("sync() returned error: %v", err)
+	}
+	assertInstances(t, registry, []string{"first", "other"})
+}
+
+func TestTargetManagerSyncKubernetesDuplicates(t *testing.T) {
+	t.Parallel()
+
+	nginx := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = io.WriteString(w, validStubStatus)
+	}))
+	t.Cleanup(nginx.Close)
+	other := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = io.WriteString(w, validStubStatus)
+	}))
+	t.Cleanup(other.Close)
+
+	logger := promslog.NewNopLogger()
+	coordinator := newScrapeCoordinator(logger)
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(coordinator)
+	manager := newTargetManager(logger, coordinator)
+
+	template := config.TargetTemplate{
+		Scheme: "http",
+		Module: config.Module{Timeout: time.Second},
+	}
+	pod := func(address, name string) discovery.Target {
+		return discovery.Target{
+			Address: address,
+			Labels: map[string]string{
+				discovery.ModeLabel:        config.ModeOSS,
+				discovery.MetricsPathLabel: "/stub_status",
+				"instance_name":            name,
+			},
+		}
+	}
+	address := strings.TrimPrefix(nginx.URL, "http://")
+	otherAddress := strings.TrimPrefix(other.URL, "http://")
+
+	// two pods that resolve to the same URI, for example while a pod IP is reused
+	found := []discovery.Target{pod(address, "first"), pod(address, "second")}
+	if err := manager.sync(t.Context(), "kubernetes_sd_configs/0", discoveredTargets(logger, template, found)); err != nil {
+		t.Fatalf("sync() returned error: %v", err)
+	}
+	assertInstances(t, registry, []string{"first"})
+
+	// a static target with the same URI takes precedence over the pod
+	static := config.Target{
+		Name:   nginx.URL + "/stub_status",
+		URI:    nginx.URL + "/stub_status",
+		Labels: map[string]string{"instance_name": "static"},
+		Module: config.Module{Mode: config.ModeOSS, Timeout: time.Second},
+	}
+	if err := manager.sync(t.Context(), staticSource, []config.Target{static}); err != nil {
+		t.Fatalf("sync() returned error: %v", err)
+	}
+	assertInstances(t, registry, []string{"static"})
+
+	// later pods are still added
+	found = append(found, pod(otherAddress, "other"))
+	if err := manager.sync(t.Context(), "kubernetes_sd_configs/0", discoveredTargets(logger, template, found)); err != nil {
+		t.Fatalf("sync() returned error: %v", err)
+	}
+	assertInstances(t, registry, []string{"other", "static"})
+}
+
+func TestTargetManagerSyncDNSDuplicates(t *testing.T) {
+	t.Parallel()
+
+	nginx := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = io.WriteString(w, validStubStatus)
+	}))
+	t.Cleanup(nginx.Close)
+	other := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = io.WriteString(w, validStubStatus)
+	}))
+	t.Cleanup(other.Close)
+
+	logger := promslog.NewNopLogger()
+	coordinator := newScrapeCoordinator(logger)
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(coordinator)
+	manager := newTargetManager(logger, coordinator)
+
+	template := config.TargetTemplate{
+		Scheme: "http",
+		Path:   "/stub_status",
+		Module: config.Module{Mode: config.ModeOSS, Timeout: time.Second},
+	}
+	host, port, _ := strings.Cut(strings.TrimPrefix(nginx.URL, "http://"), ":")
+	record := func(hostname, address, instance string) discovery.Target {
+		return discovery.Target{
+			Address: address,
+			Labels:  map[string]string{"hostname": hostname, "port": port, "instance_name": instance},
+		}
+	}
+
+	// an SRV record and an A record config that yield the same host:port
+	srv := []discovery.Target{record("_nginx._tcp.example.com", host+":"+port, "srv")}
+	if err := manager.sync(t.Context(), "dns_sd_configs/0", discoveredTargets(logger, template, srv)); err != nil {
+		t.Fatalf("sync() returned error: %v", err)
+	}
+	a := []discovery.Target{record("nginx.example.com", host+":"+port, "a")}
+	if err := manager.sync(t.Context(), "dns_sd_configs/1", discoveredTargets(logger, template, a)); err != nil {
+		t.Fatalf("sync() returned error: %v", err)
+	}
+	assertInstances(t, registry, []string{"srv"})
+
+	// later records of the A record config are still added
+	a = append(a, record("nginx.example.com", strings.TrimPrefix(other.URL, "http://"), "other"))
+	if err := manager.sync(t.Context(), "dns_sd_configs/1", discoveredTargets(logger, template, a)); err != nil {
+		t.Fatalf("sync() returned error: %v", err)
+	}
+	assertInstances(t, registry, []string{"other", "srv"})
+
+	// the duplicate takes over once the SRV record is gone
+	if err := manager.sync(t.Context(), "dns_sd_configs/0", nil); err != nil {
+		t.Fatalf("sync() returned error: %v", err)
+	}
+	assertInstances(t, registry, []string{"a", "other"})
+}
+
+func collectorFor(t *testing.T, manager *targetManager, name string) prometheus.Collector {
+	t.Helper()
+
+	for _, st := range manager.targets {
+		if st.target.Name == name {
+			return st.collector
+		}
+	}
+	t.Fatalf("no collector for target %q", name)
+	return nil
+}
+
+func assertInstances(t *testing.T, registry *prometheus.Registry, want []string) {
+	t.Helper()
+
+	families, err := registry.Gather()
+	if err != nil {
+		t.Fatalf("Gather() returned error: %v", err)
+	}
+
+	var got []string
+	for _, family := range families {
+		if family.GetName() != "nginx_up" {
+			continue
+		}
+		for _, metric := range family.GetMetric() {
+			for _, label := range metric.GetLabel() {
+				if label.GetName() == "instance_name" {
+					got = append(got, label.GetValue())
+				}
+			}
+		}
+	}
+	slices.Sort(got)
+
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("registered targets = %v, want %v", got, want)
+	}
+}
+
+func TestDiscoveredTarget(t *testing.T) {
+	t.Parallel()
+
+	template := config.TargetTemplate{
+		Labels: map[string]string{"dc": "eu-west"},
+		Scheme: "http",
+		Module: config.Module{Mode: config.ModeOSS, Timeout: time.Second},
+	}
+
+	tests := []struct {
+		found   discovery.Target
+		name    string
+		want    config.Target
+		wantErr bool
+	}{
+		{
+			name:  "defaults of the template",
+			found: discovery.Target{Address: "10.0.0.1:8080", Labels: map[string]string{"env": "prod"}},
+			want: config.Target{
+				Name:   "http://10.0.0.1:8080/stub_status",
+				URI:    "http://10.0.0.1:8080/stub_status",
+				Labels: map[string]string{"addr": "http://10.0.0.1:8080/stub_status", "dc": "eu-west", "env": "prod"},
+				Module: config.Module{Mode: config.ModeOSS, Timeout: time.Second},
+			},
+		},
+		{
+			name: "reserved labels override the template",
+			found: discovery.Target{Address: "lb1:8443", Labels: map[string]string{
+				discovery.ModeLabel:   config.ModePlus,
+				discovery.SchemeLabel: "https",
+				"dc":                  "us-east",
+			}},
+			want: config.Target{
+				Name:   "https://lb1:8443/api",
+				URI:    "https://lb1:8443/api",
+				Labels: map[string]string{"addr": "https://lb1:8443/api", "dc": "us-east"},
+				Module: config.Module{Mode: config.ModePlus, Timeout: time.Second},
+			},
+		},
+		{
+			name:    "invalid label name",
+			found:   discovery.Target{Address: "10.0.0.1:8080", Labels: map[string]string{"data-center": "eu-west"}},
+			wantErr: true,
+		},
+		{
+			name:    "invalid mode",
+			found:   discovery.Target{Address: "10.0.0.1:8080", Labels: map[string]string{discovery.ModeLabel: "stub_status"}},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			got, err := discoveredTarget(template, tt.found)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("discoveredTarget() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("discoveredTarget() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,287 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/nginx/nginx-prometheus-exporter/config"
+	"software.sslmate.com/src/go-pkcs12"
+)
+
+// tlsFiles holds the TLS configuration of a scrape target along with its CA bundle and
+// client certificate, given as PEM files or as a PKCS#12 bundle. The files are read
+// again when they change, so certificates rotated on disk, for example by cert-manager,
+// are used for new connections without restarting the exporter.
+type tlsFiles struct {
+	cert               *tls.Certificate
+	roots              *x509.CertPool
+	caFile             *secretFile
+	certFile           *secretFile
+	keyFile            *secretFile
+	pkcs12File         *secretFile
+	pkcs12PasswordFile *secretFile
+	caPEM              string
+	certSource         string
+	pkcs12Password     string
+	serverName         string
+	pins               [][sha256.Size]byte
+	cipherSuites       []uint16
+	mutex              sync.Mutex
+	minVersion         uint16
+	insecureSkipVerify bool
+}
+
+// newTLSFiles loads the files of cfg and returns an error if any of them cannot be
+// loaded.
+func newTLSFiles(cfg config.TLSConfig) (*tlsFiles, error) {
+	f := &tlsFiles{
+		pkcs12Password:     cfg.PKCS12Password,
+		serverName:         cfg.ServerName,
+		minVersion:         uint16(cfg.MinVersion),
+		insecureSkipVerify: cfg.InsecureSkipVerify,
+	}
+	for _, suite := range cfg.CipherSuites {
+		f.cipherSuites = append(f.cipherSuites, uint16(suite))
+	}
+	for _, pin := range cfg.PinnedSPKISHA256 {
+		hash, err := base64.StdEncoding.DecodeString(pin)
+		if err != nil || len(hash) != sha256.Size {
+			return nil, fmt.Errorf("pinned_spki_sha256 %q is not a base64 encoded SHA-256 hash", pin)
+		}
+		f.pins = append(f.pins, [sha256.Size]byte(hash))
+	}
+
+	if cfg.CAFile != "" {
+		f.caFile = &secretFile{path: cfg.CAFile}
+		if _, err := f.rootCAs(); err != nil {
+			return nil, err
+		}
+	}
+	switch {
+	case cfg.PKCS12File != "":
+		f.pkcs12File = &secretFile{path: cfg.PKCS12File, binary: true}
+		if cfg.PKCS12PasswordFile != "" {
+			f.pkcs12PasswordFile = &secretFile{path: cfg.PKCS12PasswordFile}
+		}
+	case cfg.CertFile != "" && cfg.KeyFile != "":
+		f.certFile = &secretFile{path: cfg.CertFile}
+		f.keyFile = &secretFile{path: cfg.KeyFile}
+	default:
+		return f, nil
+	}
+	if _, err := f.clientCertificate(nil); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// newTLSConfig returns the TLS configuration of cfg. The client certificate is read
+// again when its files change, the CA bundle is the one loaded now.
+func newTLSConfig(cfg config.TLSConfig) (*tls.Config, error) {
+	f, err := newTLSFiles(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return f.config("")
+}
+
+// config returns the TLS configuration of a new connection to serverName with the
+// current CA bundle. The configured server name takes precedence over serverName.
+func (f *tlsFiles) config(serverName string) (*tls.Config, error) {
+	roots, err := f.rootCAs()
+	if err != nil {
+		return nil, err
+	}
+	if f.serverName != "" {
+		serverName = f.serverName
+	}
+
+	// #nosec G402
+	cfg := &tls.Config{
+		InsecureSkipVerify: f.insecureSkipVerify,
+		RootCAs:            roots,
+		ServerName:         serverName,
+		MinVersion:         f.minVersion,
+		CipherSuites:       f.cipherSuites,
+	}
+	if f.certFile != nil || f.pkcs12File != nil {
+		cfg.GetClientCertificate = f.clientCertificate
+	}
+	if len(f.pins) > 0 {
+		cfg.VerifyConnection = f.verifyPins
+	}
+	return cfg, nil
+}
+
+// verifyPins checks that a certificate of the verified chains of the server, which
+// include the trusted CA, has one of the pinned public keys. Other certificates sent by
+// the server are not checked, as the server only proves that it has the key of its own
+// certificate. With insecure_skip_verify, there are no verified chains and the pin must
+// match that certificate. It implements the tls.Config.VerifyConnection hook, which runs
+// after the usual verification of the chain.
+func (f *tlsFiles) verifyPins(cs tls.ConnectionState) error {
+	chains := cs.VerifiedChains
+	if f.insecureSkipVerify && len(cs.PeerCertificates) > 0 {
+		chains = [][]*x509.Certificate{cs.PeerCertificates[:1]}
+	}
+	for _, chain := range chains {
+		for _, cert := range chain {
+			if slices.Contains(f.pins, sha256.Sum256(cert.RawSubjectPublicKeyInfo)) {
+				return nil
+			}
+		}
+	}
+	return &tls.CertificateVerificationError{
+		UnverifiedCertificates: cs.PeerCertificates,
+		Err:                    errors.New("no verified certificate of the server has a pinned public key"),
+	}
+}
+
+// rootCAs returns the certificates of the CA bundle, or nil to use the system roots.
+func (f *tlsFiles) rootCAs() (*x509.CertPool, error) {
+	if f.caFile == nil {
+		return nil, nil //nolint:nilnil // nil selects the system roots
+	}
+
+	caPEM, err := f.caFile.get()
+	if err != nil {
+		return nil, fmt.Errorf("loading CA cert failed: %w", err)
+	}
+
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	if f.roots == nil || caPEM != f.caPEM {
+		roots := x509.NewCertPool()
+		if !roots.AppendCertsFromPEM([]byte(caPEM)) {
+			return nil, fmt.Errorf("parsing CA cert file %q failed", f.caFile.path)
+		}
+		f.roots, f.caPEM = roots, caPEM
+	}
+	return f.roots, nil
+}
+
+// clientCertificate returns the client certificate. It implements the
+// tls.Config.GetClientCertificate hook.
+func (f *tlsFiles) clientCertificate(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+	var source string
+	var load func() (tls.Certificate, error)
+	if f.pkcs12File != nil {
+		bundle, err := f.pkcs12File.get()
+		if err != nil {
+			return nil, fmt.Errorf("loading PKCS#12 bundle failed: %w", err)
+		}
+		password := f.pkcs12Password
+		if f.pkcs12PasswordFile != nil {
+			if password, err = f.pkcs12PasswordFile.get(); err != nil {
+				return nil, fmt.Errorf("loading PKCS#12 password failed: %w", err)
+			}
+		}
+		source = bundle + "\x00" + password
+		load = func() (tls.Certificate, error) {
+			return decodePKCS12(bundle, password)
+		}
+	} else {
+		certPEM, err := f.certFile.get()
+		if err != nil {
+			return nil, fmt.Errorf("loading client certificate failed: %w", err)
+		}
+		keyPEM, err := f.keyFile.get()
+		if err != nil {
+			return nil, fmt.Errorf("loading client certificate failed: %w", err)
+		}
+		source = certPEM + "\x00" + keyPEM
+		load = func() (tls.Certificate, error) {
+			cert, err := tls.X509KeyPair([]byte(certPEM), []byte(keyPEM))
+			if err != nil {
+				return tls.Certificate{}, fmt.Errorf("loading client certificate failed: %w", err)
+			}
+			return cert, nil
+		}
+	}
+
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	if f.cert == nil || source != f.certSource {
+		cert, err := load()
+		if err != nil {
+			return nil, err
+		}
+		f.cert, f.certSource = &cert, source
+	}
+	return f.cert, nil
+}
+
+// decodePKCS12 returns the client certificate and key in a PKCS#12 bundle, followed by
+// the rest of the chain in the bundle.
+func decodePKCS12(bundle, password string) (tls.Certificate, error) {
+	key, leaf, chain, err := pkcs12.DecodeChain([]byte(bundle), password)
+	if err != nil {
+		return tls.Certificate{}, fmt.Errorf("decoding PKCS#12 bundle failed: %w", err)
+	}
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return tls.Certificate{}, fmt.Errorf("encoding key of PKCS#12 bundle failed: %w", err)
+	}
+
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: leaf.Raw})
+	for _, cert := range chain {
+		certPEM = append(certPEM, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})...)
+	}
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER})
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		return tls.Certificate{}, fmt.Errorf("loading client certificate of PKCS#12 bundle failed: %w", err)
+	}
+	return cert, nil
+}
+
+// clientCertificateExpiry returns the expiry time of the loaded client certificate, or
+// the zero time if there is none.
+func (f *tlsFiles) clientCertificateExpiry() time.Time {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	if f.cert == nil || f.cert.Leaf == nil {
+		return time.Time{}
+	}
+	return f.cert.Leaf.NotAfter
+}
+
+// newDialTLSContext returns the function that opens the TLS connections of a scrape
+// target over the connections of dialContext. Every connection gets a new TLS
+// configuration, so it verifies NGINX with the current CA bundle.
+func newDialTLSContext(dialContext func(ctx context.Context, network, addr string) (net.Conn, error), files *tlsFiles) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		host, _, err := net.SplitHostPort(addr)
+		if err != nil {
+			return nil, fmt.Errorf("parsing address %q failed: %w", addr, err)
+		}
+		cfg, err := files.config(host)
+		if err != nil {
+			return nil, err
+		}
+
+		conn, err := dialContext(ctx, network, addr)
+		if err != nil {
+			return nil, err
+		}
+		tlsConn := tls.Client(conn, cfg)
+		if err := tlsConn.HandshakeContext(ctx); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("TLS handshake with %s failed: %w", addr, err)
+		}
+		return tlsConn, nil
+	}
+}
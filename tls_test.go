@@ -0,0 +1,441 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"log"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/nginx/nginx-prometheus-exporter/collector"
+	"github.com/nginx/nginx-prometheus-exporter/config"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/prometheus/common/promslog"
+)
+
+// testCertificate is a certificate with its key, signed by a test CA.
+type testCertificate struct {
+	cert    *x509.Certificate
+	key     *ecdsa.PrivateKey
+	certPEM []byte
+	keyPEM  []byte
+}
+
+// newTestCertificate creates a certificate from template, signed by parent, or
+// self-signed if parent is nil.
+func newTestCertificate(t *testing.T, template *x509.Certificate, parent *testCertificate) *testCertificate {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template.SerialNumber = big.NewInt(time.Now().UnixNano())
+	if template.NotBefore.IsZero() {
+		template.NotBefore = time.Now().Add(-time.Hour)
+	}
+	if template.NotAfter.IsZero() {
+		template.NotAfter = time.Now().Add(time.Hour)
+	}
+
+	parentCert, parentKey := template, key
+	if parent != nil {
+		parentCert, parentKey = parent.cert, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parentCert, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &testCertificate{
+		cert:    cert,
+		key:     key,
+		certPEM: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		keyPEM:  pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}),
+	}
+}
+
+func newTestCA(t *testing.T, name string) *testCertificate {
+	t.Helper()
+
+	return newTestCertificate(t, &x509.Certificate{
+		Subject:               pkix.Name{CommonName: name},
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}, nil)
+}
+
+func (c *testCertificate) tlsCertificate(t *testing.T) tls.Certificate {
+	t.Helper()
+
+	cert, err := tls.X509KeyPair(c.certPEM, c.keyPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert
+}
+
+func TestScrapeTargetTLSReload(t *testing.T) {
+	t.Parallel()
+
+	ca1, ca2 := newTestCA(t, "ca-1"), newTestCA(t, "ca-2")
+	newServerCert := func(ca *testCertificate) tls.Certificate {
+		return newTestCertificate(t, &x509.Certificate{
+			Subject:     pkix.Name{CommonName: "nginx"},
+			IPAddresses: []net.IP{net.ParseIP("127.0.0.1")},
+			ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		}, ca).tlsCertificate(t)
+	}
+	newClientCert := func(name string, notAfter time.Time) *testCertificate {
+		return newTestCertificate(t, &x509.Certificate{
+			Subject:     pkix.Name{CommonName: name},
+			NotAfter:    notAfter,
+			ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		}, ca1)
+	}
+
+	// NGINX requires a client certificate signed by the first CA and returns its name
+	var serverCert atomic.Pointer[tls.Certificate]
+	cert1 := newServerCert(ca1)
+	serverCert.Store(&cert1)
+	clientCAs := x509.NewCertPool()
+	clientCAs.AddCert(ca1.cert)
+	nginx := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, r.TLS.PeerCertificates[0].Subject.CommonName)
+	}))
+	nginx.TLS = &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			return &tls.Config{
+				MinVersion:   tls.VersionTLS12,
+				Certificates: []tls.Certificate{*serverCert.Load()},
+				ClientAuth:   tls.RequireAndVerifyClientCert,
+				ClientCAs:    clientCAs,
+			}, nil
+		},
+	}
+	nginx.Config.ErrorLog = log.New(io.Discard, "", 0)
+	nginx.StartTLS()
+	t.Cleanup(nginx.Close)
+
+	dir := t.TempDir()
+	caFile, certFile, keyFile := filepath.Join(dir, "ca.pem"), filepath.Join(dir, "client.pem"), filepath.Join(dir, "client.key")
+	modTime := time.Now()
+	writeFile := func(path string, content []byte) {
+		t.Helper()
+		if err := os.WriteFile(path, content, 0o600); err != nil {
+			t.Fatal(err)
+		}
+		// a new modification time, even on file systems with a coarse resolution
+		modTime = modTime.Add(time.Second)
+		if err := os.Chtimes(path, modTime, modTime); err != nil {
+			t.Fatal(err)
+		}
+	}
+	client1 := newClientCert("client-1", time.Now().Add(24*time.Hour).Truncate(time.Second))
+	writeFile(caFile, ca1.certPEM)
+	writeFile(certFile, client1.certPEM)
+	writeFile(keyFile, client1.keyPEM)
+
+	logger := promslog.NewNopLogger()
+	coordinator := newScrapeCoordinator(logger)
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(coordinator)
+	st, err := newScrapeTarget(logger, config.Target{
+		Name: "nginx",
+		URI:  nginx.URL,
+		Module: config.Module{
+			Mode:      config.ModeOSS,
+			Timeout:   time.Second,
+			TLSConfig: config.TLSConfig{CAFile: caFile, CertFile: certFile, KeyFile: keyFile},
+		},
+	}, coordinator)
+	if err != nil {
+		t.Fatalf("newScrapeTarget() returned error: %v", err)
+	}
+	t.Cleanup(st.close)
+	if err := coordinator.add(st); err != nil {
+		t.Fatalf("add() returned error: %v", err)
+	}
+
+	// get returns the name of the client certificate received by NGINX on a new connection
+	get := func() (string, error) {
+		st.httpClient.CloseIdleConnections()
+		req, err := http.NewRequestWithContext(t.Context(), http.MethodGet, nginx.URL, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp, err := st.httpClient.Do(req)
+		if err != nil {
+			return "", err
+		}
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		return string(body), err
+	}
+	expectExpiry := func(want time.Time) {
+		t.Helper()
+		expected := fmt.Sprintf(`
+# HELP nginx_exporter_tls_client_certificate_expiry_timestamp_seconds Expiry time of the client certificate loaded for the target (expressed as Unix Epoch Time)
+# TYPE nginx_exporter_tls_client_certificate_expiry_timestamp_seconds gauge
+nginx_exporter_tls_client_certificate_expiry_timestamp_seconds{target="nginx"} %d
+`, want.Unix())
+		if err := testutil.GatherAndCompare(registry, strings.NewReader(expected), "nginx_exporter_tls_client_certificate_expiry_timestamp_seconds"); err != nil {
+			t.Error(err)
+		}
+	}
+
+	if got, err := get(); err != nil || got != "client-1" {
+		t.Fatalf("client certificate = %q, %v, want %q", got, err, "client-1")
+	}
+	expectExpiry(client1.cert.NotAfter)
+
+	// the rotated client certificate is used for new connections
+	client2 := newClientCert("client-2", time.Now().Add(48*time.Hour).Truncate(time.Second))
+	writeFile(certFile, client2.certPEM)
+	writeFile(keyFile, client2.keyPEM)
+	if got, err := get(); err != nil || got != "client-2" {
+		t.Errorf("client certificate after rotation = %q, %v, want %q", got, err, "client-2")
+	}
+	expectExpiry(client2.cert.NotAfter)
+
+	// NGINX is not trusted once its certificate is signed by another CA, until the CA
+	// bundle is rotated as well
+	cert2 := newServerCert(ca2)
+	serverCert.Store(&cert2)
+	_, err = get()
+	if got := collector.ClassifyScrapeError(err); got != collector.ScrapeErrorTLS {
+		t.Errorf("ClassifyScrapeError(%v) = %q, want %q", err, got, collector.ScrapeErrorTLS)
+	}
+	writeFile(caFile, ca2.certPEM)
+	if got, err := get(); err != nil || got != "client-2" {
+		t.Errorf("client certificate after rotating the CA = %q, %v, want %q", got, err, "client-2")
+	}
+}
+
+func TestNewHTTPClientTLSOptions(t *testing.T) {
+	t.Parallel()
+
+	// NGINX with a certificate for nginx.example.com only, TLS 1.2 and a single cipher
+	// suite, which accepts client certificates of the CA of the PKCS#12 bundle in testdata
+	ca := newTestCA(t, "ca")
+	serverCert := newTestCertificate(t, &x509.Certificate{
+		Subject:     pkix.Name{CommonName: "nginx"},
+		DNSNames:    []string{"nginx.example.com"},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}, ca)
+	clientCA, err := os.ReadFile(filepath.Join("testdata", "client-ca.pem"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientCAs := x509.NewCertPool()
+	clientCAs.AppendCertsFromPEM(clientCA)
+	nginx := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		client := "-"
+		if len(r.TLS.PeerCertificates) > 0 {
+			client = r.TLS.PeerCertificates[0].Subject.CommonName
+		}
+		_, _ = io.WriteString(w, r.TLS.ServerName+" "+client)
+	}))
+	nginx.TLS = &tls.Config{
+		Certificates: []tls.Certificate{serverCert.tlsCertificate(t)},
+		MinVersion:   tls.VersionTLS12,
+		MaxVersion:   tls.VersionTLS12,
+		CipherSuites: []uint16{tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256},
+		ClientAuth:   tls.VerifyClientCertIfGiven,
+		ClientCAs:    clientCAs,
+	}
+	nginx.Config.ErrorLog = log.New(io.Discard, "", 0)
+	nginx.StartTLS()
+	t.Cleanup(nginx.Close)
+
+	dir := t.TempDir()
+	caFile, passwordFile := filepath.Join(dir, "ca.pem"), filepath.Join(dir, "password")
+	if err := os.WriteFile(caFile, ca.certPEM, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(passwordFile, []byte("s3cret\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	caPin := sha256.Sum256(ca.cert.RawSubjectPublicKeyInfo)
+	otherPin := sha256.Sum256([]byte("other public key"))
+
+	tests := []struct {
+		name      string
+		wantBody  string
+		wantClass string
+		tlsConfig config.TLSConfig
+	}{
+		{
+			name:      "server name of the certificate",
+			tlsConfig: config.TLSConfig{CAFile: caFile, ServerName: "nginx.example.com"},
+			wantBody:  "nginx.example.com -",
+		},
+		{
+			name:      "address not in the certificate",
+			tlsConfig: config.TLSConfig{CAFile: caFile},
+			wantClass: collector.ScrapeErrorTLS,
+		},
+		{
+			name:      "minimum version not supported by the server",
+			tlsConfig: config.TLSConfig{CAFile: caFile, ServerName: "nginx.example.com", MinVersion: config.TLSVersion(tls.VersionTLS13)},
+			wantClass: collector.ScrapeErrorTLS,
+		},
+		{
+			name: "cipher suite supported by the server",
+			tlsConfig: config.TLSConfig{
+				CAFile:       caFile,
+				ServerName:   "nginx.example.com",
+				CipherSuites: []config.CipherSuite{config.CipherSuite(tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256)},
+			},
+			wantBody: "nginx.example.com -",
+		},
+		{
+			name: "cipher suite not supported by the server",
+			tlsConfig: config.TLSConfig{
+				CAFile:       caFile,
+				ServerName:   "nginx.example.com",
+				CipherSuites: []config.CipherSuite{config.CipherSuite(tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384)},
+			},
+			wantClass: collector.ScrapeErrorTLS,
+		},
+		{
+			name: "pinned public key of the CA",
+			tlsConfig: config.TLSConfig{
+				CAFile:           caFile,
+				ServerName:       "nginx.example.com",
+				PinnedSPKISHA256: []string{base64.StdEncoding.EncodeToString(caPin[:])},
+			},
+			wantBody: "nginx.example.com -",
+		},
+		{
+			name: "pinned public key without certificate verification",
+			tlsConfig: config.TLSConfig{
+				InsecureSkipVerify: true,
+				PinnedSPKISHA256:   []string{base64.StdEncoding.EncodeToString(otherPin[:])},
+			},
+			wantClass: collector.ScrapeErrorTLS,
+		},
+		{
+			name: "client certificate of a PKCS#12 bundle",
+			tlsConfig: config.TLSConfig{
+				CAFile:             caFile,
+				ServerName:         "nginx.example.com",
+				PKCS12File:         filepath.Join("testdata", "client.p12"),
+				PKCS12PasswordFile: passwordFile,
+			},
+			wantBody: "nginx.example.com pkcs12-client",
+		},
+	}
+
+	for _, tt := range tests {
+		httpClient, endpoint, err := newHTTPClient(nginx.URL, config.Module{Timeout: time.Second, TLSConfig: tt.tlsConfig})
+		if err != nil {
+			t.Fatalf("%s: newHTTPClient() returned error: %v", tt.name, err)
+		}
+		t.Cleanup(httpClient.CloseIdleConnections)
+
+		req, err := http.NewRequestWithContext(t.Context(), http.MethodGet, endpoint, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp, err := httpClient.Do(req)
+		if err != nil {
+			if got := collector.ClassifyScrapeError(err); got != tt.wantClass {
+				t.Errorf("%s: ClassifyScrapeError(%v) = %q, want %q", tt.name, err, got, tt.wantClass)
+			}
+			continue
+		}
+		body, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil || string(body) != tt.wantBody || tt.wantClass != "" {
+			t.Errorf("%s: got %q, %v, want %q with error class %q", tt.name, body, err, tt.wantBody, tt.wantClass)
+		}
+	}
+
+	if _, _, err := newHTTPClient(nginx.URL, config.Module{TLSConfig: config.TLSConfig{
+		PKCS12File:     filepath.Join("testdata", "client.p12"),
+		PKCS12Password: "wrong",
+	}}); err == nil {
+		t.Error("newHTTPClient() with a wrong PKCS#12 password returned no error")
+	}
+}
+
+func TestNewHTTPClientPinnedKeyNotProven(t *testing.T) {
+	t.Parallel()
+
+	// an impostor sends its own certificate, signed by a CA that is trusted as well, and
+	// appends the pinned CA certificate, whose key it does not have
+	pinnedCA, otherCA := newTestCA(t, "pinned-ca"), newTestCA(t, "other-ca")
+	impostorCert := newTestCertificate(t, &x509.Certificate{
+		Subject:     pkix.Name{CommonName: "impostor"},
+		IPAddresses: []net.IP{net.ParseIP("127.0.0.1")},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}, otherCA).tlsCertificate(t)
+	impostorCert.Certificate = append(impostorCert.Certificate, pinnedCA.cert.Raw)
+	impostor := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = io.WriteString(w, validStubStatus)
+	}))
+	impostor.TLS = &tls.Config{Certificates: []tls.Certificate{impostorCert}, MinVersion: tls.VersionTLS12}
+	impostor.Config.ErrorLog = log.New(io.Discard, "", 0)
+	impostor.StartTLS()
+	t.Cleanup(impostor.Close)
+
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	if err := os.WriteFile(caFile, append(slices.Clone(pinnedCA.certPEM), otherCA.certPEM...), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	pin := sha256.Sum256(pinnedCA.cert.RawSubjectPublicKeyInfo)
+	pins := []string{base64.StdEncoding.EncodeToString(pin[:])}
+
+	for _, tlsConfig := range []config.TLSConfig{
+		{CAFile: caFile, PinnedSPKISHA256: pins},
+		{InsecureSkipVerify: true, PinnedSPKISHA256: pins},
+	} {
+		httpClient, endpoint, err := newHTTPClient(impostor.URL, config.Module{Timeout: time.Second, TLSConfig: tlsConfig})
+		if err != nil {
+			t.Fatalf("newHTTPClient() returned error: %v", err)
+		}
+		t.Cleanup(httpClient.CloseIdleConnections)
+
+		req, err := http.NewRequestWithContext(t.Context(), http.MethodGet, endpoint, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp, err := httpClient.Do(req)
+		if err == nil {
+			resp.Body.Close()
+		}
+		if got := collector.ClassifyScrapeError(err); got != collector.ScrapeErrorTLS {
+			t.Errorf("insecure_skip_verify %v: ClassifyScrapeError(%v) = %q, want %q", tlsConfig.InsecureSkipVerify, err, got, collector.ScrapeErrorTLS)
+		}
+	}
+}